@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/fixtures"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+const (
+	ReloaderKey = "reloader"
+	FixturesKey = "fixtures"
+)
+
+type ReloadResponse struct {
+	Changes []config.Change `json:"changes"`
+}
+
+// AdminReload re-reads the configuration and applies it, the same as sending SIGHUP.
+func AdminReload(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	reloader, ok := ctx.Value(ReloaderKey).(*config.Reloader)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	changes, err := reloader.Reload()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(ReloadResponse{changes}); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// AdminFixtures reloads the fixtures, resetting fixture mailboxes to their initial contents.
+func AdminFixtures(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	loader, ok := ctx.Value(FixturesKey).(*fixtures.Loader)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	summary, err := loader.Load()
+	if err != nil {
+		if err == fixtures.ErrNotConfigured {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
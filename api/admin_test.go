@@ -0,0 +1,130 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/fixtures"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&AdminSuite{})
+
+type AdminSuite struct {
+	registry *mailbox.Registry
+	server   *httptest.Server
+	next     config.Config
+	fixtures string
+}
+
+func (s *AdminSuite) SetUpTest(c *check.C) {
+	s.next = config.Default()
+	s.fixtures = ""
+	reloader := config.NewReloader(config.Default(), func() (config.Config, error) {
+		return s.next, nil
+	})
+
+	s.registry = mailbox.NewRegistry()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "registry", s.registry)
+	ctx = context.WithValue(ctx, "reloader", reloader)
+	ctx = context.WithValue(ctx, "fixtures", fixtures.NewLoader(s.registry, func() string { return s.fixtures }))
+	s.server = httptest.NewServer(ponyexpress.New(ctx))
+}
+
+func (s *AdminSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.registry.Close()
+	mailbox.Configure(mailbox.DefaultSettings())
+}
+
+func (s *AdminSuite) TestReload(c *check.C) {
+	s.next.Limits.MaxMessageSize = 1024
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/admin/reload"
+	resp, err := http.Post(uri.String(), "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	var content api.ReloadResponse
+	err = json.NewDecoder(resp.Body).Decode(&content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Changes, check.HasLen, 1)
+	c.Assert(content.Changes[0].Setting, check.Equals, "limits.max_message_size")
+	c.Assert(mailbox.CurrentSettings().MaxMessageSize, check.Equals, 1024)
+}
+
+func (s *AdminSuite) TestReloadInvalid(c *check.C) {
+	s.next.Retention.SizeLimit = -1
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/admin/reload"
+	resp, err := http.Post(uri.String(), "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 500)
+	c.Assert(mailbox.CurrentSettings().SizeLimit, check.Equals, 500)
+}
+
+func (s *AdminSuite) TestFixtures(c *check.C) {
+	s.fixtures = filepath.Join(c.MkDir(), "fixtures.json")
+	content := `{"mailboxes": [{"id": "demo", "pinned": true, "messages": [
+		{"id": "1", "sender": "brett@buddin.us", "subject": "Welcome", "received": "-10m"}
+	]}]}`
+	c.Assert(ioutil.WriteFile(s.fixtures, []byte(content), 0644), check.IsNil)
+
+	box, _ := s.registry.Create("demo")
+	box.Push(&mailbox.Message{ID: "2", Sender: "brett@buddin.us"})
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/admin/fixtures"
+	resp, err := http.Post(uri.String(), "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	var summary fixtures.Summary
+	err = json.NewDecoder(resp.Body).Decode(&summary)
+	c.Assert(err, check.IsNil)
+	c.Assert(summary, check.DeepEquals, fixtures.Summary{Mailboxes: 1, Messages: 1})
+
+	box, err = s.registry.Get("demo")
+	c.Assert(err, check.IsNil)
+	messages := box.Messages()
+	c.Assert(messages, check.HasLen, 1)
+	c.Assert(messages[0].ID, check.Equals, "1")
+	c.Assert(messages[0].Pinned, check.Equals, true)
+}
+
+func (s *AdminSuite) TestFixturesNotConfigured(c *check.C) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/admin/fixtures"
+	resp, err := http.Post(uri.String(), "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 404)
+}
+
+func (s *AdminSuite) TestFixturesInvalid(c *check.C) {
+	s.fixtures = filepath.Join(c.MkDir(), "fixtures.json")
+	c.Assert(ioutil.WriteFile(s.fixtures, []byte(`{"mailboxes": [{"id": "demo", "bogus": 1}]}`), 0644), check.IsNil)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/admin/fixtures"
+	resp, err := http.Post(uri.String(), "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 500)
+	_, err = s.registry.Get("demo")
+	c.Assert(err, check.NotNil)
+}
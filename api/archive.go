@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/archive"
+	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+const (
+	// maxImportSize bounds the size of an archive accepted by MessageImport.
+	maxImportSize = 256 << 20
+
+	// maxImportExpanded bounds the size of the messages in an archive once it's decompressed.
+	maxImportExpanded = 1 << 30
+
+	// maxImportMessages bounds the number of messages in an archive.
+	maxImportMessages = 100000
+
+	// importOverhead allows for the header fields an archive records beyond those counted by the mailbox's maximum
+	// message size.
+	importOverhead = 64 << 10
+)
+
+// ImportResponse lists the IDs of imported messages, oldest first.
+type ImportResponse struct {
+	Imported int      `json:"imported"`
+	IDs      []string `json:"ids"`
+}
+
+// archiveFormat reads the format query parameter, which defaults to mbox.
+func archiveFormat(r *server.Request) (*archive.Format, error) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = archive.MBox
+	}
+	return archive.Lookup(name)
+}
+
+// MessageExport writes every message in a mailbox, oldest first, as an archive for download.
+func MessageExport(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	box, err := registry.Get(r.URLParams.ByName(ParamAddress))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	format, err := archiveFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, box.ID, format.Extension))
+	w.WriteHeader(http.StatusOK)
+	if err := format.Write(w, box.Messages()); err != nil {
+		httpLog.Error("failure to write archive", logger.Fields{"error": err, "mailbox": box.ID})
+	}
+}
+
+// MessageImport loads the messages in an archive into a mailbox. Messages keep the ID recorded in the archive unless
+// the mailbox already holds a message with that ID, and keep their received time when the archive records one. An
+// archive that doesn't fit in the mailbox alongside its messages is refused, so every ID returned was kept.
+func MessageImport(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	box, err := registry.Get(r.URLParams.ByName(ParamAddress))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	format, err := archiveFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	messages, err := format.Read(r.Body, archive.Limits{
+		MessageSize: box.MaxSize() + importOverhead,
+		TotalSize:   maxImportExpanded,
+		Messages:    maxImportMessages,
+	})
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("archive exceeds maximum size of %d bytes", maxImportSize))
+			return
+		}
+		if _, ok := err.(*archive.LimitError); ok {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s archive: %s", format.Name, err))
+		return
+	}
+
+	now := registry.Clock().Now()
+	ids := map[string]bool{}
+	for _, msg := range messages {
+		if _, err := box.Get(msg.ID); msg.ID == "" || err == nil || ids[msg.ID] {
+			msg.ID = uuid.NewV4().String()
+		}
+		ids[msg.ID] = true
+		if msg.Received.IsZero() {
+			msg.Received = now
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Received.Before(messages[j].Received) })
+
+	if err := box.Import(messages); err != nil {
+		if err == mailbox.ErrMessageTooLarge {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("archive holds a message exceeding the maximum size of %d bytes", box.MaxSize()))
+			return
+		}
+		if err == mailbox.ErrMailboxFull {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("archive holds more messages than the mailbox has room for (size_limit %d)", mailbox.CurrentSettings().SizeLimit))
+			return
+		}
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	resp := ImportResponse{Imported: len(messages), IDs: make([]string, len(messages))}
+	for i, msg := range messages {
+		resp.IDs[i] = msg.ID
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+package api_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&ArchiveSuite{})
+
+type ArchiveSuite struct {
+	registry *mailbox.Registry
+	server   *httptest.Server
+}
+
+func (s *ArchiveSuite) SetUpTest(c *check.C) {
+	s.registry = mailbox.NewRegistry()
+	ctx := context.WithValue(context.Background(), "registry", s.registry)
+	s.server = httptest.NewServer(ponyexpress.New(ctx))
+}
+
+func (s *ArchiveSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.registry.Close()
+}
+
+func (s *ArchiveSuite) TestExportImport(c *check.C) {
+	received := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	box, _ := s.registry.Create("a")
+	box.Push(&mailbox.Message{ID: "1", Sender: "brett@buddin.us", Subject: "Howdy", Body: "Some text\n", Received: received})
+	box.Update("1", mailbox.MessageUpdate{Tags: []string{"ci"}})
+	s.registry.Create("b")
+
+	for _, format := range []string{"mbox", "maildir-tar", "eml-zip"} {
+		resp, err := http.Get(s.server.URL + "/mailboxes/a/export?format=" + format)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 200)
+		c.Assert(resp.Header.Get("Content-Disposition"), check.Matches, `attachment; filename="a\..*"`)
+		archive, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		resp, err = http.Post(s.server.URL+"/mailboxes/b/import?format="+format, "application/octet-stream", bytes.NewReader(archive))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 201)
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		validateSchema(c, buf, "../schemas/import.json")
+
+		var imported api.ImportResponse
+		c.Assert(json.Unmarshal(buf, &imported), check.IsNil)
+		c.Assert(imported.Imported, check.Equals, 1)
+		b, err := s.registry.Get("b")
+		c.Assert(err, check.IsNil)
+		got, err := b.Get(imported.IDs[0])
+		c.Assert(err, check.IsNil)
+		c.Assert(got.Subject, check.Equals, "Howdy")
+		c.Assert(got.Received.Equal(received), check.Equals, true)
+		c.Assert(got.Tags, check.DeepEquals, []string{"ci"})
+	}
+
+	// The first import kept the original ID; later ones had to be given new IDs.
+	b, _ := s.registry.Get("b")
+	messages := b.Messages()
+	c.Assert(len(messages), check.Equals, 3)
+	c.Assert(messages[0].ID, check.Equals, "1")
+	c.Assert(messages[1].ID, check.Not(check.Equals), "1")
+}
+
+func (s *ArchiveSuite) TestImportPreservesHeaders(c *check.C) {
+	s.registry.Create("a")
+	mbox := "From someone@example.com Thu Jun 30 12:00:00 2016\n" +
+		"Received: from mx.example.com by mx.example.org; Thu, 30 Jun 2016 12:17:36 +0000\n" +
+		"From: someone@example.com\n" +
+		"To: a@example.com\n" +
+		"Subject: Hello\n" +
+		"\n" +
+		"Hi\n"
+	resp, err := http.Post(s.server.URL+"/mailboxes/a/import", "application/mbox", strings.NewReader(mbox))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 201)
+
+	resp, err = http.Get(s.server.URL + "/mailboxes/a/messages")
+	c.Assert(err, check.IsNil)
+	buf, _ := ioutil.ReadAll(resp.Body)
+	validateSchema(c, buf, "../schemas/message_index.json")
+
+	box, _ := s.registry.Get("a")
+	msg := box.Messages()[0]
+	c.Assert(msg.Headers["To"], check.DeepEquals, []string{"a@example.com"})
+	c.Assert(msg.Received.Equal(time.Date(2016, 6, 30, 12, 17, 36, 0, time.UTC)), check.Equals, true)
+}
+
+func (s *ArchiveSuite) TestImportErrors(c *check.C) {
+	box, _ := s.registry.Create("a")
+	box.SetMaxSize(10)
+
+	tests := []struct {
+		path, body string
+		status     int
+		err        string
+	}{
+		{"/mailboxes/missing/import", "", 404, "unknown mailbox: missing"},
+		{"/mailboxes/a/import?format=pst", "", 400, "unknown archive format: pst (want mbox, maildir-tar or eml-zip)"},
+		{"/mailboxes/a/import", "Subject: nope\n", 400, "invalid mbox archive: not an mbox file: expected a From line"},
+		{"/mailboxes/a/import", "From a Thu Jun 30 12:00:00 2016\nSubject: much too long\n\nbody\n", 413,
+			"archive holds a message exceeding the maximum size of 10 bytes"},
+		{"/mailboxes/a/export?format=pst", "", 400, "unknown archive format: pst (want mbox, maildir-tar or eml-zip)"},
+	}
+	for _, test := range tests {
+		method := "POST"
+		if strings.Contains(test.path, "export") {
+			method = "GET"
+		}
+		req, _ := http.NewRequest(method, s.server.URL+test.path, strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, test.status, check.Commentf(test.path))
+		var body struct{ Error string }
+		json.NewDecoder(resp.Body).Decode(&body)
+		c.Assert(body.Error, check.Equals, test.err)
+	}
+	c.Assert(len(box.Messages()), check.Equals, 0)
+}
+
+func (s *ArchiveSuite) TestImportZipBomb(c *check.C) {
+	box, _ := s.registry.Create("a")
+	box.SetMaxSize(1 << 10)
+
+	// A small upload holding a message far larger than the mailbox accepts once it's inflated.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("bomb.eml")
+	fw.Write([]byte("Subject: boom\r\n\r\n"))
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < 256; i++ {
+		fw.Write(zeros)
+	}
+	c.Assert(zw.Close(), check.IsNil)
+	c.Assert(buf.Len() < 1<<20, check.Equals, true)
+
+	resp, err := http.Post(s.server.URL+"/mailboxes/a/import?format=eml-zip", "application/zip", &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 413)
+	var body struct{ Error string }
+	json.NewDecoder(resp.Body).Decode(&body)
+	c.Assert(body.Error, check.Matches, "archive exceeds the limit of .* bytes per message")
+	c.Assert(len(box.Messages()), check.Equals, 0)
+}
+
+func (s *ArchiveSuite) TestImportFull(c *check.C) {
+	settings := mailbox.DefaultSettings()
+	settings.SizeLimit = 2
+	mailbox.Configure(settings)
+	defer mailbox.Configure(mailbox.DefaultSettings())
+
+	box, _ := s.registry.Create("a")
+	box.Push(&mailbox.Message{ID: "existing", Sender: "brett@buddin.us", Received: time.Now()})
+
+	archive := "From a Thu Jun 30 12:00:00 2016\nSubject: one\n\nbody\n\n" +
+		"From a Thu Jun 30 12:01:00 2016\nSubject: two\n\nbody\n"
+	resp, err := http.Post(s.server.URL+"/mailboxes/a/import", "application/mbox", strings.NewReader(archive))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 413)
+	var body struct{ Error string }
+	json.NewDecoder(resp.Body).Decode(&body)
+	c.Assert(body.Error, check.Equals, "archive holds more messages than the mailbox has room for (size_limit 2)")
+	c.Assert(len(box.Messages()), check.Equals, 1)
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// maxBatchSize caps the number of items a single batch request may operate on.
+const maxBatchSize = 1000
+
+// BatchResult is the outcome of a batch operation on a single item.
+type BatchResult struct {
+	ID      string           `json:"id"`
+	Status  int              `json:"status"`
+	Error   string           `json:"error,omitempty"`
+	Mailbox *MailboxInfo     `json:"mailbox,omitempty"`
+	Message *mailbox.Message `json:"message,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+// ClockKey is the Context key of the *mailbox.ManualClock the Registry tells the time by in test mode. The clock
+// endpoints answer 404 when there is none.
+const ClockKey = "clock"
+
+var errTestMode = fmt.Errorf("test mode is disabled")
+
+// ClockResponse describes the clock after a change. Evicted counts the messages that expired because of it.
+type ClockResponse struct {
+	Now     time.Time `json:"now"`
+	Frozen  bool      `json:"frozen"`
+	Evicted int       `json:"evicted"`
+}
+
+// ClockSetPayload moves the clock to a time.
+type ClockSetPayload struct {
+	Time time.Time `json:"time"`
+}
+
+// ClockAdvancePayload moves the clock by a duration, such as "1h30m".
+type ClockAdvancePayload struct {
+	Duration string `json:"duration"`
+}
+
+// EvictResponse counts the messages removed by an eviction.
+type EvictResponse struct {
+	Evicted int `json:"evicted"`
+}
+
+// AdminClock shows the time in test mode.
+func AdminClock(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	clock, ok := ctx.Value(ClockKey).(*mailbox.ManualClock)
+	if !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+	writeClock(w, clock, 0)
+}
+
+// AdminClockFreeze stops the clock.
+func AdminClockFreeze(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	clock, ok := ctx.Value(ClockKey).(*mailbox.ManualClock)
+	if !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+	clock.Freeze()
+	writeClock(w, clock, 0)
+}
+
+// AdminClockResume starts a frozen clock again from the time it shows.
+func AdminClockResume(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	clock, ok := ctx.Value(ClockKey).(*mailbox.ManualClock)
+	if !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+	clock.Resume()
+	writeClock(w, clock, 0)
+}
+
+// AdminClockSet moves the clock to a time and evicts the messages that have expired by then.
+func AdminClockSet(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	clock, ok := ctx.Value(ClockKey).(*mailbox.ManualClock)
+	if !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+
+	var in ClockSetPayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Time.IsZero() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("time is required, in RFC 3339 format"))
+		return
+	}
+
+	clock.Set(in.Time)
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	writeClock(w, clock, registry.Evict())
+}
+
+// AdminClockAdvance moves the clock forwards, or backwards for a negative duration, and evicts the messages that have
+// expired by then.
+func AdminClockAdvance(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	clock, ok := ctx.Value(ClockKey).(*mailbox.ManualClock)
+	if !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+
+	var in ClockAdvancePayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(in.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %q", in.Duration))
+		return
+	}
+
+	clock.Advance(d)
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	writeClock(w, clock, registry.Evict())
+}
+
+// AdminEvict evicts expired messages from every mailbox now, rather than when the eviction goroutine next runs.
+func AdminEvict(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	if _, ok := ctx.Value(ClockKey).(*mailbox.ManualClock); !ok {
+		writeError(w, http.StatusNotFound, errTestMode)
+		return
+	}
+
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(EvictResponse{registry.Evict()}); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+func writeClock(w server.ResponseWriter, clock *mailbox.ManualClock, evicted int) {
+	w.WriteHeader(http.StatusOK)
+	resp := ClockResponse{Now: clock.Now(), Frozen: clock.Frozen(), Evicted: evicted}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
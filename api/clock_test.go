@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&ClockSuite{})
+
+type ClockSuite struct {
+	clock    *mailbox.ManualClock
+	registry *mailbox.Registry
+	server   *httptest.Server
+}
+
+func (s *ClockSuite) SetUpTest(c *check.C) {
+	s.clock = mailbox.NewManualClock()
+	s.registry = mailbox.NewRegistryWithClock(s.clock)
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "registry", s.registry)
+	ctx = context.WithValue(ctx, "clock", s.clock)
+	s.server = httptest.NewServer(ponyexpress.New(ctx))
+}
+
+func (s *ClockSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.registry.Close()
+}
+
+func (s *ClockSuite) post(c *check.C, path, body string) (*http.Response, api.ClockResponse) {
+	resp, err := http.Post(s.server.URL+path, "application/json", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var content api.ClockResponse
+	if resp.StatusCode == 200 {
+		validateSchema(c, buf, "../schemas/clock.json")
+		c.Assert(json.NewDecoder(bytes.NewReader(buf)).Decode(&content), check.IsNil)
+	}
+	return resp, content
+}
+
+func (s *ClockSuite) TestFreezeAndResume(c *check.C) {
+	resp, content := s.post(c, "/admin/clock/freeze", "")
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(content.Frozen, check.Equals, true)
+	frozen := content.Now
+
+	resp, err := http.Get(s.server.URL + "/admin/clock")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	json.NewDecoder(resp.Body).Decode(&content)
+	c.Assert(content.Now.Equal(frozen), check.Equals, true)
+
+	resp, content = s.post(c, "/admin/clock/resume", "")
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(content.Frozen, check.Equals, false)
+	c.Assert(content.Now.Before(frozen), check.Equals, false)
+}
+
+func (s *ClockSuite) TestSet(c *check.C) {
+	s.clock.Freeze()
+	resp, content := s.post(c, "/admin/clock/set", `{"time": "2016-06-30T08:17:36Z"}`)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(content.Now.Equal(time.Date(2016, 6, 30, 8, 17, 36, 0, time.UTC)), check.Equals, true)
+
+	resp, _ = s.post(c, "/admin/clock/set", `{}`)
+	c.Assert(resp.StatusCode, check.Equals, 400)
+}
+
+func (s *ClockSuite) TestAdvanceEvicts(c *check.C) {
+	s.clock.Freeze()
+	box, _ := s.registry.Create("a")
+
+	resp, err := http.Post(s.server.URL+"/mailboxes/a/messages", "application/json",
+		strings.NewReader(`{"message": {"sender": "brett@buddin.us", "subject": "Reset your password", "body": "Link"}}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 201)
+	c.Assert(box.Messages()[0].Received.Equal(s.clock.Now()), check.Equals, true)
+
+	resp, content := s.post(c, "/admin/clock/advance", `{"duration": "59m"}`)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(content.Evicted, check.Equals, 0)
+
+	resp, content = s.post(c, "/admin/clock/advance", `{"duration": "2m"}`)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(content.Evicted, check.Equals, 1)
+	c.Assert(box.Messages(), check.HasLen, 0)
+
+	resp, _ = s.post(c, "/admin/clock/advance", `{"duration": "soon"}`)
+	c.Assert(resp.StatusCode, check.Equals, 400)
+}
+
+func (s *ClockSuite) TestEvict(c *check.C) {
+	box, _ := s.registry.Create("a")
+	box.Push(&mailbox.Message{ID: "1", Sender: "brett@buddin.us", Received: s.clock.Now().Add(-2 * time.Hour)})
+	box.Push(&mailbox.Message{ID: "2", Sender: "brett@buddin.us", Received: s.clock.Now()})
+
+	resp, err := http.Post(s.server.URL+"/admin/evict", "application/json", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	var content api.EvictResponse
+	c.Assert(json.NewDecoder(resp.Body).Decode(&content), check.IsNil)
+	c.Assert(content.Evicted, check.Equals, 1)
+}
+
+func (s *ClockSuite) TestDisabled(c *check.C) {
+	registry := mailbox.NewRegistry()
+	defer registry.Close()
+	server := httptest.NewServer(ponyexpress.New(context.WithValue(context.Background(), "registry", registry)))
+	defer server.Close()
+
+	for _, path := range []string{"/admin/clock/freeze", "/admin/clock/advance", "/admin/evict"} {
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(`{"duration": "1h"}`))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 404)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+var errInvalidCursor = fmt.Errorf("invalid cursor")
+
+// cursor is the wire form of a mailbox.Cursor. Clients treat the encoded value as opaque.
+type cursor struct {
+	ID       string `json:"i"`
+	Seq      uint64 `json:"s"`
+	Received int64  `json:"r"`
+}
+
+// encodeCursor encodes a zero Received time as 0, since UnixNano is undefined for it.
+func encodeCursor(c *mailbox.Cursor) string {
+	var received int64
+	if !c.Received.IsZero() {
+		received = c.Received.UnixNano()
+	}
+	buf, _ := json.Marshal(cursor{ID: c.ID, Seq: c.Seq, Received: received})
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(s string) (*mailbox.Cursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+	var received time.Time
+	if c.Received != 0 {
+		received = time.Unix(0, c.Received)
+	}
+	return &mailbox.Cursor{ID: c.ID, Seq: c.Seq, Received: received}, nil
+}
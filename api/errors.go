@@ -3,19 +3,22 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"golang.org/x/net/context"
 
+	"github.com/brettbuddin/ponyexpress/logger"
 	"github.com/brettbuddin/ponyexpress/server"
 )
 
+var httpLog = logger.New("http")
+
 var (
 	errMethodNotAllowed    = fmt.Errorf("method not allowed")
 	errInternalServerError = fmt.Errorf("internal server error")
 	errBadRequest          = fmt.Errorf("bad request")
 	errNotFound            = fmt.Errorf("not found")
+	errTooManyRequests     = fmt.Errorf("too many requests")
 )
 
 type errResp struct {
@@ -26,7 +29,7 @@ func writeError(w http.ResponseWriter, status int, err error) {
 	w.WriteHeader(status)
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(errResp{err.Error()}); err != nil {
-		log.Printf("failure to write error: %s (%d)\n", err, status)
+		httpLog.Error("failure to write error", logger.Fields{"error": err, "status": status})
 	}
 }
 
@@ -34,6 +37,10 @@ func PanicRecovery(ctx context.Context, w server.ResponseWriter, r *server.Reque
 	writeError(w, http.StatusInternalServerError, errInternalServerError)
 }
 
+func TooManyRequests(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	writeError(w, http.StatusTooManyRequests, errTooManyRequests)
+}
+
 func NotFound(ctx context.Context, w server.ResponseWriter, r *server.Request) {
 	writeError(w, http.StatusInternalServerError, errNotFound)
 }
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+const (
+	ReadinessKey = "readiness"
+	BuildKey     = "build"
+)
+
+// Readiness tracks the checks that must all pass before ponyexpress is ready for traffic, such as the listeners being
+// bound. It's safe for concurrent use.
+type Readiness struct {
+	mu     sync.RWMutex
+	checks map[string]bool
+}
+
+// NewReadiness creates a Readiness whose checks start out failing.
+func NewReadiness(checks ...string) *Readiness {
+	r := &Readiness{checks: map[string]bool{}}
+	for _, check := range checks {
+		r.checks[check] = false
+	}
+	return r
+}
+
+// Set records whether a check passes.
+func (r *Readiness) Set(check string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[check] = ok
+}
+
+// Status reports whether every check passes, along with the result of each.
+func (r *Readiness) Status() (bool, map[string]bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ready := true
+	checks := make(map[string]bool, len(r.checks))
+	for check, ok := range r.checks {
+		checks[check] = ok
+		ready = ready && ok
+	}
+	return ready, checks
+}
+
+// Build describes the running binary.
+type Build struct {
+	Version  string
+	Commit   string
+	Started  time.Time
+	Features []string
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Ready  bool            `json:"ready"`
+	Checks map[string]bool `json:"checks"`
+}
+
+type VersionResponse struct {
+	Version       string    `json:"version"`
+	Commit        string    `json:"commit"`
+	GoVersion     string    `json:"go_version"`
+	Started       time.Time `json:"started"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Features      []string  `json:"features"`
+}
+
+// Healthz reports that the process is up and able to answer requests.
+func Healthz(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(HealthResponse{"ok"}); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// Readyz reports whether ponyexpress is ready for traffic: 200 when every check in the Readiness passes and 503
+// otherwise.
+func Readyz(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	_, ok := ctx.Value(RegistryKey).(*mailbox.Registry)
+	resp := ReadinessResponse{Ready: ok, Checks: map[string]bool{"storage": ok}}
+	if readiness, ok := ctx.Value(ReadinessKey).(*Readiness); ok {
+		resp.Ready, resp.Checks = readiness.Status()
+	}
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// Version describes the running binary: its version, the commit it was built from, the Go release it was built with,
+// how long it has been running and which optional features are enabled.
+func Version(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	build, ok := ctx.Value(BuildKey).(Build)
+	if !ok {
+		build = Build{Version: "dev", Commit: "unknown"}
+	}
+	features := append([]string{}, build.Features...)
+	sort.Strings(features)
+
+	resp := VersionResponse{
+		Version:   build.Version,
+		Commit:    build.Commit,
+		GoVersion: runtime.Version(),
+		Started:   build.Started,
+		Features:  features,
+	}
+	if !build.Started.IsZero() {
+		uptime := time.Since(build.Started)
+		resp.Uptime = uptime.Truncate(time.Second).String()
+		resp.UptimeSeconds = uptime.Seconds()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
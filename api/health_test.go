@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&HealthSuite{})
+
+type HealthSuite struct {
+	registry  *mailbox.Registry
+	readiness *api.Readiness
+	server    *httptest.Server
+}
+
+func (s *HealthSuite) SetUpTest(c *check.C) {
+	s.registry = mailbox.NewRegistry()
+	s.readiness = api.NewReadiness("listener", "storage")
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "registry", s.registry)
+	ctx = context.WithValue(ctx, "readiness", s.readiness)
+	ctx = context.WithValue(ctx, "build", api.Build{
+		Version:  "1.2.3",
+		Commit:   "abc123",
+		Started:  time.Now().Add(-time.Minute),
+		Features: []string{"tls", "metrics"},
+	})
+	s.server = httptest.NewServer(ponyexpress.New(ctx))
+}
+
+func (s *HealthSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.registry.Close()
+}
+
+func (s *HealthSuite) get(c *check.C, path string) (*http.Response, []byte) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = path
+	resp, err := http.Get(uri.String())
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	return resp, buf
+}
+
+func (s *HealthSuite) TestHealthz(c *check.C) {
+	resp, buf := s.get(c, "/healthz")
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+	validateSchema(c, buf, "../schemas/health.json")
+}
+
+func (s *HealthSuite) TestReadyz(c *check.C) {
+	resp, buf := s.get(c, "/readyz")
+	c.Assert(resp.StatusCode, check.Equals, 503)
+	validateSchema(c, buf, "../schemas/readiness.json")
+
+	var content api.ReadinessResponse
+	c.Assert(json.Unmarshal(buf, &content), check.IsNil)
+	c.Assert(content.Ready, check.Equals, false)
+	c.Assert(content.Checks, check.DeepEquals, map[string]bool{"listener": false, "storage": false})
+
+	s.readiness.Set("listener", true)
+	s.readiness.Set("storage", true)
+	resp, buf = s.get(c, "/readyz")
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	validateSchema(c, buf, "../schemas/readiness.json")
+	c.Assert(json.Unmarshal(buf, &content), check.IsNil)
+	c.Assert(content.Ready, check.Equals, true)
+}
+
+func (s *HealthSuite) TestVersion(c *check.C) {
+	resp, buf := s.get(c, "/version")
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	validateSchema(c, buf, "../schemas/version.json")
+
+	var content api.VersionResponse
+	c.Assert(json.Unmarshal(buf, &content), check.IsNil)
+	c.Assert(content.Version, check.Equals, "1.2.3")
+	c.Assert(content.Commit, check.Equals, "abc123")
+	c.Assert(content.GoVersion, check.Equals, runtime.Version())
+	c.Assert(content.Uptime, check.Equals, "1m0s")
+	c.Assert(content.UptimeSeconds >= 60, check.Equals, true)
+	c.Assert(content.Features, check.DeepEquals, []string{"metrics", "tls"})
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/satori/go.uuid"
@@ -14,19 +16,157 @@ import (
 const RegistryKey = "registry"
 
 type MailboxResponse struct {
-	Mailbox *mailbox.Mailbox `json:"mailbox"`
+	Mailbox *MailboxInfo `json:"mailbox"`
+}
+
+// MailboxInfo describes a single mailbox. It's a snapshot taken through the mailbox's accessors, so encoding it never
+// races with changes to the mailbox itself.
+type MailboxInfo struct {
+	ID             string `json:"id"`
+	MaxMessageSize int    `json:"max_message_size"`
+}
+
+func newMailboxInfo(box *mailbox.Mailbox) *MailboxInfo {
+	return &MailboxInfo{ID: box.ID, MaxMessageSize: box.MaxSize()}
+}
+
+// MailboxSummary describes a mailbox and its contents in a MailboxListResponse.
+type MailboxSummary struct {
+	ID             string `json:"id"`
+	MaxMessageSize int    `json:"max_message_size"`
+	Messages       int    `json:"messages"`
+	Bytes          int    `json:"bytes"`
+}
+
+type MailboxListResponse struct {
+	Mailboxes []MailboxSummary `json:"mailboxes"`
+}
+
+// MailboxIndex lists every mailbox, ordered by address.
+func MailboxIndex(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+
+	boxes := registry.List()
+	resp := MailboxListResponse{Mailboxes: make([]MailboxSummary, len(boxes))}
+	for i, box := range boxes {
+		messages, bytes := box.Stats()
+		resp.Mailboxes[i] = MailboxSummary{
+			ID:             box.ID,
+			MaxMessageSize: box.MaxSize(),
+			Messages:       messages,
+			Bytes:          bytes,
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// MailboxPayload is the optional body of a mailbox creation request.
+type MailboxPayload struct {
+	Mailbox struct {
+		MaxMessageSize int `json:"max_message_size"`
+	} `json:"mailbox"`
+}
+
+// validateMaxMessageSize checks a mailbox's own limit, which may lower the MaxMessageSize setting but not raise it.
+func validateMaxMessageSize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("max_message_size must not be negative")
+	}
+	if limit := mailbox.CurrentSettings().MaxMessageSize; size > limit {
+		return fmt.Errorf("max_message_size must not exceed %d", limit)
+	}
+	return nil
 }
 
 func MailboxCreate(ctx context.Context, w server.ResponseWriter, r *server.Request) {
 	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
-	box, err := registry.Create(uuid.NewV4().String())
+
+	// An empty body asks for a mailbox without options.
+	var in MailboxPayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+	if err := validateMaxMessageSize(in.Mailbox.MaxMessageSize); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	box, err := registry.CreateWithOptions(uuid.NewV4().String(), mailbox.Options{
+		MaxMessageSize: in.Mailbox.MaxMessageSize,
+	})
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(MailboxResponse{newMailboxInfo(box)}); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// MailboxBatchPayload is the body of a batch mailbox creation request.
+type MailboxBatchPayload struct {
+	Mailboxes []struct {
+		MaxMessageSize int `json:"max_message_size"`
+	} `json:"mailboxes"`
+}
+
+// MailboxAction dispatches actions registered on /mailboxes/:address. The router can't hold both a static and a
+// wildcard segment at the same position, so the action name arrives as the address.
+func MailboxAction(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	switch r.URLParams.ByName(ParamAddress) {
+	case "batch":
+		MailboxBatchCreate(ctx, w, r)
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func MailboxBatchCreate(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+
+	var in MailboxBatchPayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+	if len(in.Mailboxes) == 0 || len(in.Mailboxes) > maxBatchSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("between 1 and %d mailboxes are required", maxBatchSize))
+		return
+	}
+
+	ids := make([]string, len(in.Mailboxes))
+	opts := make([]mailbox.Options, len(in.Mailboxes))
+	for i, m := range in.Mailboxes {
+		if err := validateMaxMessageSize(m.MaxMessageSize); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ids[i] = uuid.NewV4().String()
+		opts[i] = mailbox.Options{MaxMessageSize: m.MaxMessageSize}
+	}
+
+	boxes, err := registry.CreateManyWithOptions(ids, opts)
 	if err != nil {
 		writeError(w, http.StatusConflict, err)
 		return
 	}
 
+	resp := BatchResponse{Results: make([]BatchResult, len(boxes))}
+	for i, box := range boxes {
+		resp.Results[i] = BatchResult{ID: box.ID, Status: http.StatusCreated, Mailbox: newMailboxInfo(box)}
+	}
+
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(MailboxResponse{box}); err != nil {
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		writeError(w, http.StatusInternalServerError, errInternalServerError)
 		return
 	}
@@ -42,7 +182,7 @@ func MailboxDelete(ctx context.Context, w server.ResponseWriter, r *server.Reque
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(MailboxResponse{box}); err != nil {
+	if err := json.NewEncoder(w).Encode(MailboxResponse{newMailboxInfo(box)}); err != nil {
 		writeError(w, http.StatusInternalServerError, errInternalServerError)
 		return
 	}
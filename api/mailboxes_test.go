@@ -1,16 +1,20 @@
 package api_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/context"
 
 	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
 	"github.com/brettbuddin/ponyexpress/mailbox"
 
 	"gopkg.in/check.v1"
@@ -57,6 +61,101 @@ func (s *MailboxSuite) TestMailboxCreate(c *check.C) {
 	validateSchema(c, buf, "../schemas/mailbox.json")
 }
 
+func (s *MailboxSuite) TestMailboxIndex(c *check.C) {
+	b, err := s.registry.Create("b")
+	c.Assert(err, check.IsNil)
+	b.Push(&mailbox.Message{ID: "1", Sender: "brett@buddin.us", Subject: "subject", Body: "body"})
+	a, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	a.SetMaxSize(1024)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes"
+	resp, err := http.Get(uri.String())
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/mailbox_index.json")
+
+	var content api.MailboxListResponse
+	c.Assert(json.Unmarshal(buf, &content), check.IsNil)
+	c.Assert(content.Mailboxes, check.DeepEquals, []api.MailboxSummary{
+		{ID: "a", MaxMessageSize: 1024},
+		{ID: "b", MaxMessageSize: mailbox.DefaultSettings().MaxMessageSize, Messages: 1, Bytes: 26},
+	})
+}
+
+func (s *MailboxSuite) TestMailboxCreateMaxMessageSize(c *check.C) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes"
+
+	body := bytes.NewBufferString(`{"mailbox":{"max_message_size":1024}}`)
+	req, err := http.NewRequest(http.MethodPost, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 201)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/mailbox.json")
+
+	var content struct {
+		Mailbox struct {
+			ID             string `json:"id"`
+			MaxMessageSize int    `json:"max_message_size"`
+		} `json:"mailbox"`
+	}
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Mailbox.MaxMessageSize, check.Equals, 1024)
+
+	box, err := s.registry.Get(content.Mailbox.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(box.MaxSize(), check.Equals, 1024)
+}
+
+func (s *MailboxSuite) TestMailboxCreateBody(c *check.C) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes"
+
+	// Only an empty body asks for a mailbox without options.
+	for _, body := range []string{"", "{}"} {
+		resp, err := http.Post(uri.String(), contentTypeJSON, strings.NewReader(body))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 201, check.Commentf("body %q", body))
+		resp.Body.Close()
+	}
+	c.Assert(s.registry.List(), check.HasLen, 2)
+
+	// A mailbox's limit may lower the server's but not raise it.
+	tooLarge := fmt.Sprintf(`{"max_message_size":%d}`, mailbox.CurrentSettings().MaxMessageSize+1)
+	for _, item := range []string{`{"max_message_size":-1}`, tooLarge} {
+		resp, err := http.Post(uri.String(), contentTypeJSON, strings.NewReader(`{"mailbox":`+item+`}`))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 400, check.Commentf("mailbox %s", item))
+		resp.Body.Close()
+
+		resp, err = http.Post(uri.String()+"/batch", contentTypeJSON, strings.NewReader(`{"mailboxes":[{},`+item+`]}`))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 400, check.Commentf("batch %s", item))
+		resp.Body.Close()
+	}
+
+	// Bodies that aren't the JSON expected are refused rather than ignored.
+	for _, body := range []string{"hello", `{"mailbox": 5}`} {
+		resp, err := http.Post(uri.String(), contentTypeJSON, strings.NewReader(body))
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 400, check.Commentf("body %q", body))
+		resp.Body.Close()
+	}
+	c.Assert(s.registry.List(), check.HasLen, 2)
+}
+
 func (s *MailboxSuite) TestMailboxDelete(c *check.C) {
 	mailbox, err := s.registry.Create("a")
 	c.Assert(err, check.IsNil)
@@ -81,6 +180,35 @@ func (s *MailboxSuite) TestMailboxDelete(c *check.C) {
 	c.Assert(err, check.NotNil)
 }
 
+func (s *MailboxSuite) TestMailboxDeleteWhileResizing(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 1000; i++ {
+			box.SetMaxSize(i)
+		}
+	}()
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s", box.ID)
+
+	req, err := http.NewRequest(http.MethodDelete, uri.String(), nil)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	<-done
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/mailbox.json")
+}
+
 func (s *MailboxSuite) TestMailboxDelete404(c *check.C) {
 	_, err := s.registry.Create("a")
 	c.Assert(err, check.IsNil)
@@ -101,3 +229,52 @@ func (s *MailboxSuite) TestMailboxDelete404(c *check.C) {
 	c.Assert(err, check.IsNil)
 	validateSchema(c, buf, "../schemas/error.json")
 }
+
+func (s *MailboxSuite) TestMailboxBatchCreate(c *check.C) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes/batch"
+
+	body := bytes.NewBufferString(`{"mailboxes":[{},{},{"max_message_size":1024}]}`)
+	req, err := http.NewRequest(http.MethodPost, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 201)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/batch.json")
+
+	var content api.BatchResponse
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Results, check.HasLen, 3)
+	for _, result := range content.Results {
+		c.Assert(result.Status, check.Equals, 201)
+		_, err := s.registry.Get(result.ID)
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(content.Results[2].Mailbox.MaxMessageSize, check.Equals, 1024)
+}
+
+func (s *MailboxSuite) TestMailboxBatchCreateEmpty(c *check.C) {
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes/batch"
+
+	body := bytes.NewBufferString(`{"mailboxes":[]}`)
+	req, err := http.NewRequest(http.MethodPost, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 400)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/error.json")
+}
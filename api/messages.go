@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
@@ -14,11 +13,32 @@ import (
 	"github.com/brettbuddin/ponyexpress/server"
 )
 
+const (
+	// payloadOverhead is the room, in bytes, allowed for the JSON envelope around a message's content when limiting
+	// the size of a request body.
+	payloadOverhead = 4 << 10
+
+	// maxEscapeGrowth is how many bytes of JSON a byte of content can take at most, written as \u00XX.
+	maxEscapeGrowth = 6
+)
+
 const (
 	ParamMessageID = "message_id"
 	ParamAddress   = "address"
 	ParamLimit     = "limit"
 	ParamSinceID   = "since_id"
+	ParamSinceSeq  = "since_seq"
+	ParamSeen      = "seen"
+	ParamFlagged   = "flagged"
+	ParamTag       = "tag"
+	ParamAfter     = "after"
+	ParamBefore    = "before"
+	ParamOrder     = "order"
+)
+
+const (
+	OrderAscending  = "asc"
+	OrderDescending = "desc"
 )
 
 type MessageResponse struct {
@@ -31,10 +51,16 @@ type MessageListResponse struct {
 }
 
 type Meta struct {
-	Results int    `json:"results"`
-	Limit   int    `json:"limit"`
-	SinceID string `json:"since_id"`
-	LastID  string `json:"last_id"`
+	Results    int    `json:"results"`
+	Limit      int    `json:"limit"`
+	SinceID    string `json:"since_id"`
+	LastID     string `json:"last_id"`
+	Order      string `json:"order"`
+	Dropped    uint64 `json:"dropped"`
+	Next       string `json:"next,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Prev       string `json:"prev,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 func MessageIndex(ctx context.Context, w server.ResponseWriter, r *server.Request) {
@@ -51,12 +77,14 @@ func MessageIndex(ctx context.Context, w server.ResponseWriter, r *server.Reques
 		return
 	}
 
-	messages := box.List(params.SinceID, params.Limit)
-
-	var lastID string
-	if len(messages) > 0 {
-		lastID = messages[0].ID
-	}
+	page := box.Page(mailbox.Query{
+		After:   params.After,
+		Before:  params.Before,
+		Limit:   params.Limit,
+		Order:   params.Order,
+		Filters: params.Filters,
+	})
+	messages := page.Messages
 
 	resp := MessageListResponse{
 		Messages: messages,
@@ -64,9 +92,44 @@ func MessageIndex(ctx context.Context, w server.ResponseWriter, r *server.Reques
 			Results: len(messages),
 			Limit:   params.Limit,
 			SinceID: params.SinceID,
-			LastID:  lastID,
+			Order:   OrderDescending,
+			Dropped: page.Dropped,
 		},
 	}
+	if params.Order == mailbox.Ascending {
+		resp.Meta.Order = OrderAscending
+	}
+
+	// Work out the cursors either side of the page. Newer messages can always arrive, so there is a newer cursor
+	// whenever there is a position to continue from.
+	var older, newer *mailbox.Cursor
+	if len(messages) > 0 {
+		oldest, newest := messages[len(messages)-1], messages[0]
+		if params.Order == mailbox.Ascending {
+			oldest, newest = newest, oldest
+		}
+		resp.Meta.LastID = newest.ID
+		newer = mailbox.CursorFor(newest)
+		if params.Before == nil && params.After != nil || params.Before != nil && page.More {
+			older = mailbox.CursorFor(oldest)
+		}
+	} else if params.Before == nil && params.After != nil {
+		newer = params.After
+	}
+
+	var olderLink, newerLink pageRef
+	if older != nil {
+		olderLink = newPageRef(r, ParamBefore, older)
+	}
+	if newer != nil {
+		newerLink = newPageRef(r, ParamAfter, newer)
+	}
+	next, prev := olderLink, newerLink
+	if params.Order == mailbox.Ascending {
+		next, prev = newerLink, olderLink
+	}
+	resp.Meta.Next, resp.Meta.NextCursor = next.link, next.cursor
+	resp.Meta.Prev, resp.Meta.PrevCursor = prev.link, prev.cursor
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -112,8 +175,18 @@ func MessageCreate(ctx context.Context, w server.ResponseWriter, r *server.Reque
 		return
 	}
 
+	maxSize := box.MaxSize()
+	errTooLarge := fmt.Errorf("message exceeds maximum size of %d bytes", maxSize)
+
+	// The body is only capped at what the largest acceptable message could take once escaped; Push enforces the exact
+	// limit on the decoded content.
 	var in MessagePayload
+	r.Body = http.MaxBytesReader(w, r.Body, maxEscapeGrowth*int64(maxSize)+payloadOverhead)
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			writeError(w, http.StatusRequestEntityTooLarge, errTooLarge)
+			return
+		}
 		writeError(w, http.StatusBadRequest, errBadRequest)
 		return
 	}
@@ -135,9 +208,21 @@ func MessageCreate(ctx context.Context, w server.ResponseWriter, r *server.Reque
 		Sender:   in.Message.Sender,
 		Subject:  in.Message.Subject,
 		Body:     in.Message.Body,
-		Received: time.Now(),
+		Received: registry.Clock().Now(),
+	}
+	if err := box.Push(msg); err != nil {
+		if err == mailbox.ErrMessageTooLarge {
+			writeError(w, http.StatusRequestEntityTooLarge, errTooLarge)
+			return
+		}
+		if limited, ok := err.(*mailbox.RateLimitError); ok {
+			limited.SetHeaders(w.Header())
+			writeError(w, http.StatusTooManyRequests, limited)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
 	}
-	box.Push(msg)
 
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(MessageResponse{msg}); err != nil {
@@ -146,6 +231,58 @@ func MessageCreate(ctx context.Context, w server.ResponseWriter, r *server.Reque
 	}
 }
 
+type MessageUpdatePayload struct {
+	Message struct {
+		Seen       *bool    `json:"seen"`
+		Flagged    *bool    `json:"flagged"`
+		Tags       []string `json:"tags"`
+		AddTags    []string `json:"add_tags"`
+		RemoveTags []string `json:"remove_tags"`
+	} `json:"message"`
+}
+
+func MessageUpdate(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	box, err := registry.Get(r.URLParams.ByName(ParamAddress))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var in MessageUpdatePayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+
+	for _, tags := range [][]string{in.Message.Tags, in.Message.AddTags, in.Message.RemoveTags} {
+		for _, t := range tags {
+			if t == "" {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("tags must not be empty"))
+				return
+			}
+		}
+	}
+
+	msg, err := box.Update(r.URLParams.ByName(ParamMessageID), mailbox.MessageUpdate{
+		Seen:       in.Message.Seen,
+		Flagged:    in.Message.Flagged,
+		Tags:       in.Message.Tags,
+		AddTags:    in.Message.AddTags,
+		RemoveTags: in.Message.RemoveTags,
+	})
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(MessageResponse{msg}); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
 func MessageDelete(ctx context.Context, w server.ResponseWriter, r *server.Request) {
 	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
 
@@ -168,9 +305,100 @@ func MessageDelete(ctx context.Context, w server.ResponseWriter, r *server.Reque
 	}
 }
 
+// MessagePurge removes every message in a mailbox, or only those matched by the same filters MessageIndex accepts.
+func MessagePurge(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	box, err := registry.Get(r.URLParams.ByName(ParamAddress))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	params, err := extractListParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	removed := box.Purge(params.Filters...)
+	resp := BatchResponse{Results: make([]BatchResult, len(removed))}
+	for i, msg := range removed {
+		resp.Results[i] = BatchResult{ID: msg.ID, Status: http.StatusOK, Message: msg}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+type MessageBatchDeletePayload struct {
+	IDs []string `json:"ids"`
+}
+
+func MessageBatchDelete(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	box, err := registry.Get(r.URLParams.ByName(ParamAddress))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var in MessageBatchDeletePayload
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+	if len(in.IDs) == 0 || len(in.IDs) > maxBatchSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("between 1 and %d ids are required", maxBatchSize))
+		return
+	}
+
+	removed := box.RemoveMany(in.IDs)
+	resp := BatchResponse{Results: make([]BatchResult, len(removed))}
+	for i, msg := range removed {
+		if msg == nil {
+			resp.Results[i] = BatchResult{
+				ID:     in.IDs[i],
+				Status: http.StatusNotFound,
+				Error:  fmt.Sprintf("unknown message: %s", in.IDs[i]),
+			}
+			continue
+		}
+		resp.Results[i] = BatchResult{ID: msg.ID, Status: http.StatusOK, Message: msg}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+}
+
+// pageRef points at another page of the same listing.
+type pageRef struct {
+	link, cursor string
+}
+
+func newPageRef(r *server.Request, param string, c *mailbox.Cursor) pageRef {
+	cursor := encodeCursor(c)
+	q := r.URL.Query()
+	q.Del(ParamSinceID)
+	q.Del(ParamSinceSeq)
+	q.Del(ParamAfter)
+	q.Del(ParamBefore)
+	q.Set(param, cursor)
+	return pageRef{link: r.URL.Path + "?" + q.Encode(), cursor: cursor}
+}
+
 type ListParams struct {
 	Limit   int
 	SinceID string
+	After   *mailbox.Cursor
+	Before  *mailbox.Cursor
+	Order   mailbox.Order
+	Filters []mailbox.Filter
 }
 
 func extractListParams(r *server.Request) (*ListParams, error) {
@@ -181,6 +409,9 @@ func extractListParams(r *server.Request) (*ListParams, error) {
 		if err != nil {
 			return nil, err
 		}
+		if limit < 1 {
+			return nil, fmt.Errorf("%s must be positive", ParamLimit)
+		}
 	}
 
 	params := &ListParams{
@@ -188,5 +419,72 @@ func extractListParams(r *server.Request) (*ListParams, error) {
 		SinceID: r.FormValue(ParamSinceID),
 	}
 
+	after, before, sinceSeq := r.FormValue(ParamAfter), r.FormValue(ParamBefore), r.FormValue(ParamSinceSeq)
+	given := 0
+	for _, v := range []string{params.SinceID, sinceSeq, after, before} {
+		if v != "" {
+			given++
+		}
+	}
+	switch {
+	case given > 1:
+		return nil, fmt.Errorf("only one of %s, %s, %s and %s may be given", ParamSinceID, ParamSinceSeq, ParamAfter, ParamBefore)
+	case sinceSeq != "":
+		seq, err := strconv.ParseUint(sinceSeq, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		params.After = &mailbox.Cursor{Seq: seq}
+	case after != "":
+		c, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		params.After = c
+	case before != "":
+		c, err := decodeCursor(before)
+		if err != nil {
+			return nil, err
+		}
+		params.Before = c
+	case params.SinceID != "":
+		params.After = &mailbox.Cursor{ID: params.SinceID}
+	}
+
+	// Pages are newest first by default. Without a cursor, listings start from the oldest message (or since_id), so
+	// clients that fetch again with since_id set to the last ID walk forward through the mailbox. Asking for descending
+	// order explicitly starts from the newest message instead.
+	switch r.FormValue(ParamOrder) {
+	case "":
+		params.Order = mailbox.Descending
+	case OrderDescending:
+		params.Order = mailbox.Descending
+		if params.After == nil && params.Before == nil {
+			params.Before = &mailbox.Cursor{}
+		}
+	case OrderAscending:
+		params.Order = mailbox.Ascending
+	default:
+		return nil, fmt.Errorf("%s must be %s or %s", ParamOrder, OrderAscending, OrderDescending)
+	}
+
+	if v := r.FormValue(ParamSeen); v != "" {
+		seen, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, err
+		}
+		params.Filters = append(params.Filters, mailbox.SeenFilter(seen))
+	}
+	if v := r.FormValue(ParamFlagged); v != "" {
+		flagged, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, err
+		}
+		params.Filters = append(params.Filters, mailbox.FlaggedFilter(flagged))
+	}
+	for _, tag := range r.Form[ParamTag] {
+		params.Filters = append(params.Filters, mailbox.TagFilter(tag))
+	}
+
 	return params, nil
 }
@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -37,6 +39,7 @@ func (s *MessageSuite) SetUpTest(c *check.C) {
 func (s *MessageSuite) TearDownTest(c *check.C) {
 	s.server.Close()
 	s.registry.Close()
+	mailbox.Configure(mailbox.DefaultSettings())
 }
 
 type message struct {
@@ -172,6 +175,141 @@ func (s *MessageSuite) TestCreateMissingBody(c *check.C) {
 	validateSchema(c, buf, "../schemas/error.json")
 }
 
+func (s *MessageSuite) TestCreateTooLarge(c *check.C) {
+	mailbox, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	mailbox.SetMaxSize(10)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", mailbox.ID)
+	buf, err := json.Marshal(struct {
+		Message message `json:"message"`
+	}{
+		message{
+			Sender:  "brett@buddin.us",
+			Subject: "subject",
+			Body:    "body",
+		},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, uri.String(), bytes.NewBuffer(buf))
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 413)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/error.json")
+}
+
+func (s *MessageSuite) TestCreateRateLimited(c *check.C) {
+	settings := mailbox.DefaultSettings()
+	settings.InboundRate = 0.001
+	settings.InboundBurst = 1
+	mailbox.Configure(settings)
+
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", box.ID)
+	buf, err := json.Marshal(struct {
+		Message message `json:"message"`
+	}{
+		message{
+			Sender:  "brett@buddin.us",
+			Subject: "subject",
+			Body:    "body",
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	resp, err := http.Post(uri.String(), contentTypeJSON, bytes.NewBuffer(buf))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 201)
+
+	resp, err = http.Post(uri.String(), contentTypeJSON, bytes.NewBuffer(buf))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 429)
+	c.Assert(resp.Header.Get("Retry-After"), check.Equals, "1000")
+	c.Assert(resp.Header.Get("RateLimit-Limit"), check.Equals, "1")
+	c.Assert(resp.Header.Get("RateLimit-Remaining"), check.Equals, "0")
+
+	buf, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/error.json")
+
+	// Other mailboxes have limits of their own.
+	other, err := s.registry.Create("b")
+	c.Assert(err, check.IsNil)
+	c.Assert(other.Push(&mailbox.Message{ID: "1", Sender: "a", Subject: "b", Body: "c"}), check.IsNil)
+}
+
+func (s *MessageSuite) TestCreateBodyTooLarge(c *check.C) {
+	mailbox, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	mailbox.SetMaxSize(10)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", mailbox.ID)
+	buf, err := json.Marshal(struct {
+		Message message `json:"message"`
+	}{
+		message{
+			Sender:  "brett@buddin.us",
+			Subject: "subject",
+			Body:    strings.Repeat("a", 64<<10),
+		},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, uri.String(), bytes.NewBuffer(buf))
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 413)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/error.json")
+}
+
+func (s *MessageSuite) TestCreateEscapedBody(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	box.SetMaxSize(8 << 10)
+
+	// Control characters take six bytes each once escaped, so the request is far larger than the message.
+	post := func(size int) int {
+		sender, subject := "brett@buddin.us", "subject"
+		buf, err := json.Marshal(struct {
+			Message message `json:"message"`
+		}{
+			message{
+				Sender:  sender,
+				Subject: subject,
+				Body:    strings.Repeat("\x01", size-len(sender)-len(subject)),
+			},
+		})
+		c.Assert(err, check.IsNil)
+		c.Assert(len(buf) > 5*size, check.Equals, true)
+
+		resp, err := http.Post(s.server.URL+"/mailboxes/a/messages", contentTypeJSON, bytes.NewBuffer(buf))
+		c.Assert(err, check.IsNil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	c.Assert(post(8<<10), check.Equals, 201)
+	c.Assert(post(8<<10+1), check.Equals, 413)
+	c.Assert(box.Messages(), check.HasLen, 1)
+}
+
 func (s *MessageSuite) TestCreate404Mailbox(c *check.C) {
 	_, err := s.registry.Create("a")
 	c.Assert(err, check.IsNil)
@@ -499,3 +637,390 @@ func (s *MessageSuite) TestIndexCursorWithLimit(c *check.C) {
 	c.Assert(content.Meta.SinceID, check.Equals, "51")
 	c.Assert(content.Meta.LastID, check.Equals, "61")
 }
+
+func (s *MessageSuite) TestUpdate(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	message := &mailbox.Message{
+		ID:      "b",
+		Sender:  "brett@buddin.us",
+		Subject: "subject",
+		Body:    "body",
+	}
+	box.Push(message)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages/%s", box.ID, message.ID)
+	body := bytes.NewBufferString(`{"message":{"seen":true,"tags":["checked","qa"]}}`)
+
+	req, err := http.NewRequest(http.MethodPatch, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/message.json")
+
+	msg, err := box.Get("b")
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Seen, check.Equals, true)
+	c.Assert(msg.Flagged, check.Equals, false)
+	c.Assert(msg.Tags, check.DeepEquals, []string{"checked", "qa"})
+}
+
+func (s *MessageSuite) TestUpdate404(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages/%s", box.ID, "c")
+	body := bytes.NewBufferString(`{"message":{"seen":true}}`)
+
+	req, err := http.NewRequest(http.MethodPatch, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 404)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/error.json")
+}
+
+func (s *MessageSuite) TestIndexFilters(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		box.Push(&mailbox.Message{
+			ID:       strconv.Itoa(i),
+			Sender:   "brett@buddin.us",
+			Subject:  "subject",
+			Body:     "body",
+			Received: now,
+			Seen:     i < 4,
+			Tags:     []string{"run-" + strconv.Itoa(i%2)},
+		})
+	}
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", box.ID)
+	uri.RawQuery = url.Values{
+		"seen": []string{"false"},
+		"tag":  []string{"run-1"},
+	}.Encode()
+	req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/message_index.json")
+
+	var content struct {
+		Messages []*mailbox.Message `json:"messages"`
+		Meta     api.Meta           `json:"meta"`
+	}
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Messages, check.HasLen, 3)
+	c.Assert(content.Messages[0].ID, check.Equals, "9")
+	c.Assert(content.Messages[1].ID, check.Equals, "7")
+	c.Assert(content.Messages[2].ID, check.Equals, "5")
+}
+
+func (s *MessageSuite) TestPurge(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for i := 0; i < 10; i++ {
+		box.Push(&mailbox.Message{
+			ID:      strconv.Itoa(i),
+			Sender:  "brett@buddin.us",
+			Subject: "subject",
+			Body:    "body",
+			Flagged: i < 3,
+		})
+	}
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", box.ID)
+	uri.RawQuery = url.Values{"flagged": []string{"false"}}.Encode()
+	req, err := http.NewRequest(http.MethodDelete, uri.String(), nil)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/batch.json")
+
+	var content api.BatchResponse
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Results, check.HasLen, 7)
+	c.Assert(box.List("", 100), check.HasLen, 3)
+}
+
+func (s *MessageSuite) TestBatchDelete(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for i := 0; i < 5; i++ {
+		box.Push(&mailbox.Message{
+			ID:      strconv.Itoa(i),
+			Sender:  "brett@buddin.us",
+			Subject: "subject",
+			Body:    "body",
+		})
+	}
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages/batch-delete", box.ID)
+	body := bytes.NewBufferString(`{"ids":["1","9","3"]}`)
+	req, err := http.NewRequest(http.MethodPost, uri.String(), body)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/batch.json")
+
+	var content api.BatchResponse
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Results, check.HasLen, 3)
+	c.Assert(content.Results[0].Status, check.Equals, 200)
+	c.Assert(content.Results[1].Status, check.Equals, 404)
+	c.Assert(content.Results[1].ID, check.Equals, "9")
+	c.Assert(content.Results[2].Status, check.Equals, 200)
+	c.Assert(box.List("", 100), check.HasLen, 3)
+}
+
+func (s *MessageSuite) TestIndexPagination(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 25; i++ {
+		box.Push(&mailbox.Message{
+			ID:       strconv.Itoa(i),
+			Sender:   "brett@buddin.us",
+			Subject:  "subject",
+			Body:     "body",
+			Received: now.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	type page struct {
+		Messages []*mailbox.Message `json:"messages"`
+		Meta     api.Meta           `json:"meta"`
+	}
+	fetch := func(path string) page {
+		uri, _ := url.Parse(s.server.URL + path)
+		req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+		c.Assert(err, check.IsNil)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 200)
+
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, check.IsNil)
+		validateSchema(c, buf, "../schemas/message_index.json")
+
+		var content page
+		err = json.Unmarshal(buf, &content)
+		c.Assert(err, check.IsNil)
+		return content
+	}
+
+	// Walk backward from the newest message, ten at a time.
+	p := fetch(fmt.Sprintf("/mailboxes/%s/messages?order=desc&limit=10", box.ID))
+	c.Assert(p.Messages[0].ID, check.Equals, "24")
+	p = fetch(p.Meta.Next)
+	c.Assert(p.Messages, check.HasLen, 10)
+	c.Assert(p.Messages[0].ID, check.Equals, "14")
+	c.Assert(p.Messages[9].ID, check.Equals, "5")
+	c.Assert(p.Meta.Order, check.Equals, "desc")
+	c.Assert(p.Meta.Next, check.Not(check.Equals), "")
+
+	older := fetch(p.Meta.Next)
+	c.Assert(older.Messages, check.HasLen, 5)
+	c.Assert(older.Messages[0].ID, check.Equals, "4")
+	c.Assert(older.Meta.Next, check.Equals, "")
+
+	newer := fetch(older.Meta.Prev)
+	c.Assert(newer.Messages, check.HasLen, 10)
+	c.Assert(newer.Messages[0].ID, check.Equals, "14")
+
+	// Both listings are newest first, but only order=desc starts from the newest message.
+	p = fetch(fmt.Sprintf("/mailboxes/%s/messages?order=desc&limit=10", box.ID))
+	c.Assert(p.Messages, check.HasLen, 10)
+	c.Assert(p.Messages[0].ID, check.Equals, "24")
+	c.Assert(p.Messages[9].ID, check.Equals, "15")
+	c.Assert(p.Meta.Order, check.Equals, "desc")
+
+	p = fetch(fmt.Sprintf("/mailboxes/%s/messages?limit=10", box.ID))
+	c.Assert(p.Messages, check.HasLen, 10)
+	c.Assert(p.Messages[0].ID, check.Equals, "9")
+	c.Assert(p.Messages[9].ID, check.Equals, "0")
+	c.Assert(p.Meta.Order, check.Equals, "desc")
+
+	// Fetching again with since_id set to the last ID walks forward through every message.
+	var seen []string
+	path := fmt.Sprintf("/mailboxes/%s/messages?limit=10", box.ID)
+	for {
+		p = fetch(path)
+		if len(p.Messages) == 0 {
+			break
+		}
+		for i := len(p.Messages) - 1; i >= 0; i-- {
+			seen = append(seen, p.Messages[i].ID)
+		}
+		path = fmt.Sprintf("/mailboxes/%s/messages?limit=10&since_id=%s", box.ID, p.Meta.LastID)
+	}
+	c.Assert(seen, check.HasLen, 25)
+	for i, id := range seen {
+		c.Assert(id, check.Equals, strconv.Itoa(i))
+	}
+
+	// Ascending pages continue forward through next.
+	asc := fetch(fmt.Sprintf("/mailboxes/%s/messages?order=asc&limit=10", box.ID))
+	c.Assert(asc.Messages[0].ID, check.Equals, "0")
+	c.Assert(asc.Meta.LastID, check.Equals, "9")
+	asc = fetch(asc.Meta.Next)
+	c.Assert(asc.Messages[0].ID, check.Equals, "10")
+
+	// A cursor whose message was removed resumes from where it was.
+	_, err = box.Remove("10")
+	c.Assert(err, check.IsNil)
+	asc = fetch(fmt.Sprintf("/mailboxes/%s/messages?order=asc&limit=3&after=%s", box.ID, asc.Meta.PrevCursor))
+	c.Assert(asc.Messages[0].ID, check.Equals, "11")
+}
+
+func (s *MessageSuite) TestIndexSinceSeq(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		box.Push(&mailbox.Message{
+			ID:       strconv.Itoa(i),
+			Sender:   "brett@buddin.us",
+			Subject:  "subject",
+			Body:     "body",
+			Received: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	box.Evict(now.Add(10 * time.Minute))
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = fmt.Sprintf("/mailboxes/%s/messages", box.ID)
+	uri.RawQuery = url.Values{
+		"since_seq": []string{"4"},
+		"limit":     []string{"5"},
+	}.Encode()
+	req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+	c.Assert(err, check.IsNil)
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(resp.Header.Get(headerContentType), check.Equals, contentTypeJSON)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	validateSchema(c, buf, "../schemas/message_index.json")
+
+	var content struct {
+		Messages []*mailbox.Message `json:"messages"`
+		Meta     api.Meta           `json:"meta"`
+	}
+	err = json.Unmarshal(buf, &content)
+	c.Assert(err, check.IsNil)
+	c.Assert(content.Messages, check.HasLen, 5)
+	c.Assert(content.Messages[4].Seq, check.Equals, uint64(11))
+	c.Assert(content.Messages[0].Seq, check.Equals, uint64(15))
+	c.Assert(content.Meta.Dropped, check.Equals, uint64(6))
+}
+
+func (s *MessageSuite) TestIndexZeroTimeCursor(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	for _, id := range []string{"1", "2", "3"} {
+		box.Push(&mailbox.Message{ID: id, Sender: "brett@buddin.us", Subject: "subject", Body: "body"})
+	}
+
+	fetch := func(query string) (ids []string, meta api.Meta) {
+		resp, err := http.Get(s.server.URL + "/mailboxes/a/messages?order=asc&" + query)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 200)
+		var content struct {
+			Messages []*mailbox.Message `json:"messages"`
+			Meta     api.Meta           `json:"meta"`
+		}
+		c.Assert(json.NewDecoder(resp.Body).Decode(&content), check.IsNil)
+		resp.Body.Close()
+		for _, m := range content.Messages {
+			ids = append(ids, m.ID)
+		}
+		return ids, content.Meta
+	}
+
+	// A message without a received time encodes it as 0...
+	ids, meta := fetch("limit=1")
+	c.Assert(ids, check.DeepEquals, []string{"1"})
+	buf, err := base64.RawURLEncoding.DecodeString(meta.NextCursor)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buf), check.Matches, `.*"r":0}`)
+	ids, _ = fetch("after=" + meta.NextCursor)
+	c.Assert(ids, check.DeepEquals, []string{"2", "3"})
+
+	// ...and 0 decodes to the zero time, which positions the cursor before every message.
+	gone := base64.RawURLEncoding.EncodeToString([]byte(`{"i":"gone","s":0,"r":0}`))
+	ids, _ = fetch("after=" + gone)
+	c.Assert(ids, check.DeepEquals, []string{"1", "2", "3"})
+}
+
+func (s *MessageSuite) TestIndexBadParams(c *check.C) {
+	_, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for _, query := range []string{"limit=0", "limit=-1", "limit=ten", "after=garbage", "order=sideways"} {
+		resp, err := http.Get(s.server.URL + "/mailboxes/a/messages?" + query)
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, 400, check.Commentf("query %q", query))
+
+		buf, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, check.IsNil)
+		resp.Body.Close()
+		validateSchema(c, buf, "../schemas/error.json")
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/metrics"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+// Metrics writes the metrics in metrics.Default, along with the size of the Registry, in the Prometheus text format.
+func Metrics(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	registry := ctx.Value(RegistryKey).(*mailbox.Registry)
+	stats := registry.Stats()
+
+	set := metrics.NewSet()
+	set.Register(
+		metrics.NewGaugeFunc("ponyexpress_mailboxes", "Number of mailboxes in the registry.", func() float64 {
+			return float64(stats.Mailboxes)
+		}),
+		metrics.NewGaugeFunc("ponyexpress_messages", "Number of messages held across all mailboxes.", func() float64 {
+			return float64(stats.Messages)
+		}),
+		metrics.NewGaugeFunc("ponyexpress_message_bytes", "Bytes of message content held across all mailboxes.", func() float64 {
+			return float64(stats.Bytes)
+		}),
+	)
+
+	var buf bytes.Buffer
+	if err := metrics.Default.Write(&buf); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+	if err := set.Write(&buf); err != nil {
+		writeError(w, http.StatusInternalServerError, errInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,70 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&MetricsSuite{})
+
+type MetricsSuite struct {
+	registry *mailbox.Registry
+	server   *httptest.Server
+}
+
+func (s *MetricsSuite) SetUpTest(c *check.C) {
+	s.registry = mailbox.NewRegistry()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "registry", s.registry)
+	s.server = httptest.NewServer(ponyexpress.New(ctx))
+}
+
+func (s *MetricsSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+	s.registry.Close()
+}
+
+func (s *MetricsSuite) TestMetrics(c *check.C) {
+	box, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	box.Push(&mailbox.Message{ID: "b", Sender: "brett@buddin.us", Subject: "subject", Body: "body"})
+
+	uri, _ := url.Parse(s.server.URL)
+	uri.Path = "/mailboxes/a/messages/b"
+	_, err = http.Get(uri.String())
+	c.Assert(err, check.IsNil)
+
+	// Methods nobody registered share a label rather than each getting their own.
+	uri.Path = "/nowhere"
+	req, err := http.NewRequest("BREW", uri.String(), nil)
+	c.Assert(err, check.IsNil)
+	_, err = http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+
+	uri.Path = "/metrics"
+	resp, err := http.Get(uri.String())
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, 200)
+	c.Assert(strings.HasPrefix(resp.Header.Get(headerContentType), "text/plain"), check.Equals, true)
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	body := string(buf)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_mailboxes 1\n.*`)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_messages 1\n.*`)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_message_bytes 26\n.*`)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_http_requests_total\{method="GET",route="/mailboxes/:address/messages/:message_id",status="200"\} \d+\n.*`)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_http_requests_total\{method="other",route="unmatched",status="\d+"\} \d+\n.*`)
+	c.Assert(strings.Contains(body, "BREW"), check.Equals, false)
+	c.Assert(body, check.Matches, `(?s).*\nponyexpress_messages_evicted_total \d+\n.*`)
+}
@@ -4,27 +4,74 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/ratelimit"
 	"github.com/brettbuddin/ponyexpress/server"
+	"github.com/brettbuddin/ponyexpress/ui"
 )
 
+// CORSKey is the Context key of the server.CORSOptions used to answer cross-origin requests. Cross-origin requests are
+// refused when there are none.
+const CORSKey = "cors"
+
+// RateLimiterKey is the Context key of the *ratelimit.Limiter applied to each caller's requests. Requests aren't
+// limited when there is none.
+const RateLimiterKey = "rate_limiter"
+
 type Application struct {
 	*server.Server
 }
 
 func New(ctx context.Context) *Application {
+	filters := []server.Filter{api.SetContentType}
+	if opts, ok := ctx.Value(CORSKey).(server.CORSOptions); ok {
+		filters = append([]server.Filter{server.CORS(opts)}, filters...)
+	}
+	if limiter, ok := ctx.Value(RateLimiterKey).(*ratelimit.Limiter); ok {
+		filters = append(filters, server.RateLimit(limiter, api.TooManyRequests))
+	}
+
 	server := server.New(ctx)
-	server.AddFilters(api.SetContentType)
+	server.AddFilters(filters...)
 	server.PanicHandler = api.PanicRecovery
 	server.NotFoundHandler = api.NotFound
 
+	server.GET("/metrics", api.Metrics)
+
+	// Health
+	server.Quiet("/healthz", "/readyz", "/version")
+	server.GET("/healthz", api.Healthz)
+	server.GET("/readyz", api.Readyz)
+	server.GET("/version", api.Version)
+
+	// Web interface
+	server.GET("/ui", ui.Index)
+	server.GET("/ui/*filepath", ui.Assets)
+
+	// Administration
+	server.POST("/admin/reload", api.AdminReload)
+	server.POST("/admin/fixtures", api.AdminFixtures)
+	server.POST("/admin/evict", api.AdminEvict)
+	server.GET("/admin/clock", api.AdminClock)
+	server.POST("/admin/clock/freeze", api.AdminClockFreeze)
+	server.POST("/admin/clock/resume", api.AdminClockResume)
+	server.POST("/admin/clock/set", api.AdminClockSet)
+	server.POST("/admin/clock/advance", api.AdminClockAdvance)
+
 	// Mailboxes
+	server.GET("/mailboxes", api.MailboxIndex)
 	server.POST("/mailboxes", api.MailboxCreate)
+	server.POST("/mailboxes/:address", api.MailboxAction)
 	server.DELETE("/mailboxes/:address", api.MailboxDelete)
+	server.GET("/mailboxes/:address/export", api.MessageExport)
+	server.POST("/mailboxes/:address/import", api.MessageImport)
 
 	// Messages
 	server.GET("/mailboxes/:address/messages", api.MessageIndex)
 	server.POST("/mailboxes/:address/messages", api.MessageCreate)
+	server.DELETE("/mailboxes/:address/messages", api.MessagePurge)
+	server.POST("/mailboxes/:address/messages/batch-delete", api.MessageBatchDelete)
 	server.GET("/mailboxes/:address/messages/:message_id", api.MessageShow)
+	server.PATCH("/mailboxes/:address/messages/:message_id", api.MessageUpdate)
 	server.DELETE("/mailboxes/:address/messages/:message_id", api.MessageDelete)
 
 	return &Application{server}
@@ -0,0 +1,276 @@
+// Package archive converts the contents of a mailbox to and from mbox files, Maildir directories packed in a tar file,
+// and zip files of .eml messages.
+//
+// Messages are written in RFC 5322 format. Their ID, received time, flags and tags are recorded in X-Ponyexpress-*
+// header fields, so an exported mailbox can be imported again without losing anything. Messages from elsewhere are
+// dated by their topmost Received field when they have no X-Ponyexpress-Received field.
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// Format names.
+const (
+	MBox       = "mbox"
+	MaildirTar = "maildir-tar"
+	EMLZip     = "eml-zip"
+)
+
+// Format is an archive format.
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+
+	// Write writes messages, oldest first, as an archive.
+	Write func(w io.Writer, messages []*mailbox.Message) error
+
+	// Read reads the messages in an archive, failing with a *LimitError as soon as it goes over limits. Messages
+	// without a known received time have a zero Received.
+	Read func(r io.Reader, limits Limits) ([]*mailbox.Message, error)
+}
+
+// Limits bound how much an archive may expand to when it's read, since a small compressed archive can hold far more.
+// Zero fields are unlimited.
+type Limits struct {
+	// MessageSize bounds each message as it's stored in the archive, headers included.
+	MessageSize int
+
+	// TotalSize bounds all of the messages together.
+	TotalSize int64
+
+	// Messages bounds the number of messages.
+	Messages int
+}
+
+// LimitError reports an archive that goes over its Limits.
+type LimitError struct {
+	What  string
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("archive exceeds the limit of %d %s", e.Limit, e.What)
+}
+
+// budget counts what has been read from an archive against its Limits.
+type budget struct {
+	Limits
+	messages int
+	total    int64
+}
+
+// add counts a message of size bytes.
+func (b *budget) add(size int) error {
+	if b.MessageSize > 0 && size > b.MessageSize {
+		return &LimitError{"bytes per message", int64(b.MessageSize)}
+	}
+	b.messages++
+	if b.Messages > 0 && b.messages > b.Messages {
+		return &LimitError{"messages", int64(b.Messages)}
+	}
+	b.total += int64(size)
+	if b.TotalSize > 0 && b.total > b.TotalSize {
+		return &LimitError{"bytes in total", b.TotalSize}
+	}
+	return nil
+}
+
+// read reads a whole message from r, stopping as soon as it would go over the limits.
+func (b *budget) read(r io.Reader) ([]byte, error) {
+	if b.MessageSize > 0 {
+		r = io.LimitReader(r, int64(b.MessageSize)+1)
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return raw, b.add(len(raw))
+}
+
+var formats = []*Format{
+	{MBox, "application/mbox", ".mbox", writeMBox, readMBox},
+	{MaildirTar, "application/x-tar", ".tar", writeMaildirTar, readMaildirTar},
+	{EMLZip, "application/zip", ".zip", writeEMLZip, readEMLZip},
+}
+
+// Lookup finds a format by name.
+func Lookup(name string) (*Format, error) {
+	for _, f := range formats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown archive format: %s (want %s, %s or %s)", name, MBox, MaildirTar, EMLZip)
+}
+
+// ForFile finds a format by a file name's extension.
+func ForFile(name string) (*Format, bool) {
+	for _, f := range formats {
+		if strings.HasSuffix(strings.ToLower(name), f.Extension) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Header fields recording what RFC 5322 has no place for.
+const (
+	headerID       = "X-Ponyexpress-Id"
+	headerReceived = "X-Ponyexpress-Received"
+	headerFlags    = "X-Ponyexpress-Flags"
+	headerTags     = "X-Ponyexpress-Tags"
+	headerPrefix   = "X-Ponyexpress-"
+)
+
+// encode writes a message in RFC 5322 format with lines ending in eol. The body is written as it is.
+func encode(m *mailbox.Message, eol string) []byte {
+	var buf bytes.Buffer
+	field := func(k, v string) {
+		buf.WriteString(k + ": " + strings.NewReplacer("\r", " ", "\n", " ").Replace(v) + eol)
+	}
+
+	field("From", m.Sender)
+	field("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	if _, ok := m.Headers["Date"]; !ok {
+		field("Date", m.Received.Format(time.RFC1123Z))
+	}
+	var keys []string
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "From" || k == "Subject" || strings.HasPrefix(k, headerPrefix) {
+			continue
+		}
+		for _, v := range m.Headers[k] {
+			field(k, v)
+		}
+	}
+
+	field(headerID, m.ID)
+	field(headerReceived, m.Received.Format(time.RFC3339Nano))
+	var flags []string
+	if m.Seen {
+		flags = append(flags, "seen")
+	}
+	if m.Flagged {
+		flags = append(flags, "flagged")
+	}
+	if len(flags) > 0 {
+		field(headerFlags, strings.Join(flags, ", "))
+	}
+	if len(m.Tags) > 0 {
+		field(headerTags, strings.Join(m.Tags, ", "))
+	}
+
+	buf.WriteString(eol)
+	buf.WriteString(m.Body)
+	return buf.Bytes()
+}
+
+// ParseMessage reads a single message in RFC 5322 format, such as a .eml file.
+func ParseMessage(raw []byte) (*mailbox.Message, error) {
+	return decode(raw, time.Time{})
+}
+
+// decode reads a message in RFC 5322 format. envelope is the time the archive recorded for the message, if any; it's
+// used when the message itself doesn't say when it was received.
+func decode(raw []byte, envelope time.Time) (*mailbox.Message, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	h := parsed.Header
+	m := &mailbox.Message{
+		ID:      h.Get(headerID),
+		Sender:  h.Get("From"),
+		Subject: decodeWords(h.Get("Subject")),
+		Body:    string(body),
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, h.Get(headerReceived)); err == nil {
+		m.Received = t
+	} else if t, ok := receivedTime(h); ok {
+		m.Received = t
+	} else if !envelope.IsZero() {
+		m.Received = envelope
+	} else if t, err := h.Date(); err == nil {
+		m.Received = t
+	}
+
+	for _, flag := range splitList(h.Get(headerFlags)) {
+		switch flag {
+		case "seen":
+			m.Seen = true
+		case "flagged":
+			m.Flagged = true
+		}
+	}
+	m.Tags = splitList(h.Get(headerTags))
+
+	for k, v := range h {
+		if k == "From" || k == "Subject" || strings.HasPrefix(k, headerPrefix) {
+			continue
+		}
+		// Date fields added by encode for messages that had none aren't worth keeping.
+		if k == "Date" && h.Get(headerReceived) != "" && len(v) == 1 && v[0] == m.Received.Format(time.RFC1123Z) {
+			continue
+		}
+		if m.Headers == nil {
+			m.Headers = map[string][]string{}
+		}
+		m.Headers[k] = v
+	}
+	return m, nil
+}
+
+// receivedTime finds the time at the end of the topmost Received field, which was added by the last server to handle
+// the message.
+func receivedTime(h mail.Header) (time.Time, bool) {
+	received := h["Received"]
+	if len(received) == 0 {
+		return time.Time{}, false
+	}
+	i := strings.LastIndex(received[0], ";")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := mail.ParseDate(strings.TrimSpace(received[0][i+1:]))
+	return t, err == nil
+}
+
+func decodeWords(s string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
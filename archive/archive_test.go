@@ -0,0 +1,154 @@
+package archive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/archive"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+func messages() []*mailbox.Message {
+	received := time.Date(2016, 6, 30, 8, 17, 36, 616531006, time.UTC)
+	return []*mailbox.Message{
+		{
+			ID:       "1",
+			Sender:   "Brett <brett@buddin.us>",
+			Subject:  "Café",
+			Body:     "Some text\nFrom here on\n>From there\n",
+			Received: received,
+			Seen:     true,
+			Flagged:  true,
+			Tags:     []string{"a", "b"},
+			Headers:  map[string][]string{"To": {"a@example.com"}, "Date": {"Thu, 30 Jun 2016 04:17:36 -0400"}},
+		},
+		{
+			ID:       "2",
+			Sender:   "brett@buddin.us",
+			Subject:  "OMG",
+			Body:     "The house is on fire.",
+			Received: received.Add(time.Minute),
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{archive.MBox, archive.MaildirTar, archive.EMLZip} {
+		format, err := archive.Lookup(name)
+		Equal(t, err, nil)
+
+		var buf bytes.Buffer
+		Equal(t, format.Write(&buf, messages()), nil)
+		read, err := format.Read(&buf, archive.Limits{})
+		Equal(t, err, nil)
+		Equal(t, len(read), 2)
+
+		for i, want := range messages() {
+			got := read[i]
+			Equal(t, got.ID, want.ID)
+			Equal(t, got.Sender, want.Sender)
+			Equal(t, got.Subject, want.Subject)
+			Equal(t, got.Received.Equal(want.Received), true)
+			Equal(t, got.Seen, want.Seen)
+			Equal(t, got.Flagged, want.Flagged)
+			Equal(t, got.Tags, want.Tags)
+			Equal(t, got.Headers, want.Headers)
+		}
+		Equal(t, read[0].Body, messages()[0].Body)
+		// mbox files end every message with a newline.
+		Equal(t, strings.TrimSuffix(read[1].Body, "\n"), messages()[1].Body)
+	}
+}
+
+func TestMBoxQuoting(t *testing.T) {
+	format, _ := archive.Lookup(archive.MBox)
+	var buf bytes.Buffer
+	Equal(t, format.Write(&buf, messages()[:1]), nil)
+	MatchRegex(t, buf.String(), "^From brett@buddin.us Thu Jun 30 08:17:36 2016\n")
+	MatchRegex(t, buf.String(), "\n>From here on\n>>From there\n\n$")
+}
+
+func TestReadForeignMBox(t *testing.T) {
+	raw := "From someone@example.com Thu Jun 30 12:00:00 2016\n" +
+		"Received: from mx.example.com by mx.example.org; Thu, 30 Jun 2016 12:17:36 +0000\n" +
+		"Received: from client by mx.example.com; Thu, 30 Jun 2016 12:17:30 +0000\n" +
+		"From: someone@example.com\n" +
+		"Subject: Hello\n" +
+		"Date: Thu, 30 Jun 2016 12:00:00 +0000\n" +
+		"\n" +
+		"Hi\n" +
+		"\n" +
+		"From other@example.com Fri Jul  1 09:00:00 2016\n" +
+		"From: other@example.com\n" +
+		"Subject: Bye\n" +
+		"\n" +
+		"Bye\n"
+
+	format, _ := archive.ForFile("export.MBOX")
+	read, err := format.Read(strings.NewReader(raw), archive.Limits{})
+	Equal(t, err, nil)
+	Equal(t, len(read), 2)
+	Equal(t, read[0].ID, "")
+	Equal(t, read[0].Subject, "Hello")
+	Equal(t, read[0].Body, "Hi\n")
+	Equal(t, read[0].Received.Equal(time.Date(2016, 6, 30, 12, 17, 36, 0, time.UTC)), true)
+	Equal(t, len(read[0].Headers["Received"]), 2)
+	Equal(t, read[0].Headers["Date"], []string{"Thu, 30 Jun 2016 12:00:00 +0000"})
+
+	// Without a Received field the date on the From line is used.
+	Equal(t, read[1].Received.Equal(time.Date(2016, 7, 1, 9, 0, 0, 0, time.UTC)), true)
+
+	_, err = format.Read(strings.NewReader("Subject: not an mbox\n"), archive.Limits{})
+	NotEqual(t, err, nil)
+}
+
+func TestLimits(t *testing.T) {
+	for _, name := range []string{archive.MBox, archive.MaildirTar, archive.EMLZip} {
+		format, _ := archive.Lookup(name)
+		var buf bytes.Buffer
+		Equal(t, format.Write(&buf, messages()), nil)
+		raw := buf.Bytes()
+
+		_, err := format.Read(bytes.NewReader(raw), archive.Limits{MessageSize: 1 << 10, TotalSize: 1 << 20, Messages: 2})
+		Equal(t, err, nil)
+
+		for _, limits := range []archive.Limits{{MessageSize: 100}, {TotalSize: 300}, {Messages: 1}} {
+			_, err = format.Read(bytes.NewReader(raw), limits)
+			_, ok := err.(*archive.LimitError)
+			Equal(t, ok, true)
+		}
+	}
+}
+
+func TestZipBomb(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("bomb.eml")
+	fw.Write([]byte("Subject: boom\r\n\r\n"))
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		fw.Write(zeros)
+	}
+	Equal(t, zw.Close(), nil)
+
+	// The entry deflates to a tiny fraction of its size, but reading stops at the limit.
+	format, _ := archive.Lookup(archive.EMLZip)
+	_, err := format.Read(&buf, archive.Limits{MessageSize: 1 << 20})
+	Equal(t, err, &archive.LimitError{What: "bytes per message", Limit: 1 << 20})
+}
+
+func TestLookup(t *testing.T) {
+	_, err := archive.Lookup("pst")
+	Equal(t, err.Error(), "unknown archive format: pst (want mbox, maildir-tar or eml-zip)")
+
+	format, ok := archive.ForFile("ci-run.tar")
+	Equal(t, ok, true)
+	Equal(t, format.Name, archive.MaildirTar)
+	_, ok = archive.ForFile("notes.txt")
+	Equal(t, ok, false)
+}
@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// fromLine matches lines that would be mistaken for the start of a message in an mbox file, and the same lines
+// quoted once or more.
+var fromLine = regexp.MustCompile(`^>*From `)
+
+// writeMBox writes an mbox file in the mboxrd variant: body lines starting with "From ", however many ">" they're
+// already quoted with, are quoted with another.
+func writeMBox(w io.Writer, messages []*mailbox.Message) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range messages {
+		sender := "MAILER-DAEMON"
+		if addr, err := mail.ParseAddress(m.Sender); err == nil {
+			sender = addr.Address
+		}
+		fmt.Fprintf(bw, "From %s %s\n", sender, m.Received.UTC().Format(time.ANSIC))
+
+		content := encode(m, "\n")
+		terminated := bytes.HasSuffix(content, []byte("\n"))
+		for len(content) > 0 {
+			line := content
+			if i := bytes.IndexByte(content, '\n'); i >= 0 {
+				line = content[:i+1]
+			}
+			content = content[len(line):]
+			if fromLine.Match(line) {
+				bw.WriteByte('>')
+			}
+			bw.Write(line)
+		}
+		if !terminated {
+			bw.WriteByte('\n')
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func readMBox(r io.Reader, limits Limits) ([]*mailbox.Message, error) {
+	var (
+		messages []*mailbox.Message
+		current  *bytes.Buffer
+		envelope time.Time
+		b        = budget{Limits: limits}
+	)
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		// Drop the blank line separating messages.
+		raw := bytes.TrimSuffix(current.Bytes(), []byte("\n"))
+		if err := b.add(len(raw)); err != nil {
+			return err
+		}
+		m, err := decode(raw, envelope)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, m)
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")):
+				if err := finish(); err != nil {
+					return nil, err
+				}
+				current, envelope = &bytes.Buffer{}, fromLineTime(string(line))
+			case current == nil:
+				return nil, fmt.Errorf("not an mbox file: expected a From line")
+			case fromLine.Match(line):
+				current.Write(line[1:])
+			default:
+				current.Write(line)
+			}
+			// Stop reading a message as soon as it's too large, rather than once it's complete.
+			if current != nil && limits.MessageSize > 0 && current.Len() > limits.MessageSize+1 {
+				return nil, &LimitError{"bytes per message", int64(limits.MessageSize)}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// fromLineTime parses the date on an mbox From line, e.g. "From brett@buddin.us Thu Jun 30 12:17:36 2016".
+func fromLineTime(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return time.Time{}
+	}
+	t, err := time.Parse("Mon Jan 2 15:04:05 2006", strings.Join(fields[2:7], " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// writeMaildirTar writes a Maildir as a tar file. Seen messages go in cur with their flags in the file name, and the
+// rest in new.
+func writeMaildirTar(w io.Writer, messages []*mailbox.Message) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for _, dir := range []string{"cur/", "new/", "tmp/"} {
+		if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0700, ModTime: now}); err != nil {
+			return err
+		}
+	}
+	for _, m := range messages {
+		name := fmt.Sprintf("new/%d.%s.ponyexpress", m.Received.Unix(), m.ID)
+		if m.Seen {
+			flags := "S"
+			if m.Flagged {
+				flags = "FS"
+			}
+			name = fmt.Sprintf("cur/%d.%s.ponyexpress:2,%s", m.Received.Unix(), m.ID, flags)
+		}
+		content := encode(m, "\n")
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(content)), ModTime: m.Received}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func readMaildirTar(r io.Reader, limits Limits) ([]*mailbox.Message, error) {
+	var messages []*mailbox.Message
+	b := budget{Limits: limits}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dir := path.Base(path.Dir(hdr.Name))
+		if hdr.Typeflag != tar.TypeReg || (dir != "cur" && dir != "new") {
+			continue
+		}
+
+		raw, err := b.read(tr)
+		if err != nil {
+			return nil, err
+		}
+		m, err := decode(raw, maildirTime(hdr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+		if i := strings.LastIndex(hdr.Name, ":2,"); i >= 0 {
+			flags := hdr.Name[i+3:]
+			m.Seen = m.Seen || strings.Contains(flags, "S")
+			m.Flagged = m.Flagged || strings.Contains(flags, "F")
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// maildirTime takes the time a message was delivered from the leading number of seconds in its file name, or from
+// the file's modification time.
+func maildirTime(hdr *tar.Header) time.Time {
+	name := path.Base(hdr.Name)
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if sec, err := strconv.ParseInt(name[:i], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	return hdr.ModTime
+}
+
+// writeEMLZip writes a zip file holding each message as a .eml file, numbered oldest first.
+func writeEMLZip(w io.Writer, messages []*mailbox.Message) error {
+	zw := zip.NewWriter(w)
+	for i, m := range messages {
+		hdr := &zip.FileHeader{Name: fmt.Sprintf("%06d-%s.eml", i+1, m.ID), Method: zip.Deflate, Modified: m.Received}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(encode(m, "\r\n")); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func readEMLZip(r io.Reader, limits Limits) ([]*mailbox.Message, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are read through the budget rather than trusting the sizes the zip file claims for them.
+	var messages []*mailbox.Message
+	b := budget{Limits: limits}
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".eml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := b.read(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		m, err := decode(raw, f.Modified)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
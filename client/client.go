@@ -0,0 +1,452 @@
+// Package client talks to the ponyexpress HTTP API.
+//
+// Every method takes a Context that bounds the whole call, including any retries. Error responses are returned as
+// *Error; IsNotFound and friends classify them.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// Client is a ponyexpress API client. It's safe for concurrent use.
+type Client struct {
+	// BaseURL is the address of the API, e.g. "http://localhost:3000".
+	BaseURL string
+
+	HTTPClient *http.Client
+
+	// Retries is the number of times a request is retried after a network error or temporary error response. Requests
+	// that create something are only retried when the API refused them before doing anything: a 429, or a 503 with a
+	// Retry-After header.
+	Retries int
+
+	// RetryWait is how long to wait before the first retry. It doubles for every retry after that, unless the API
+	// asks for a particular wait with Retry-After.
+	RetryWait time.Duration
+
+	// PollInterval is how often Wait checks for new messages.
+	PollInterval time.Duration
+}
+
+// New creates a Client for the API at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:   http.DefaultClient,
+		Retries:      3,
+		RetryWait:    100 * time.Millisecond,
+		PollInterval: 250 * time.Millisecond,
+	}
+}
+
+// Mailbox is a mailbox as described by the API. Messages and Bytes are only given by ListMailboxes.
+type Mailbox struct {
+	ID             string `json:"id"`
+	MaxMessageSize int    `json:"max_message_size,omitempty"`
+	Messages       int    `json:"messages,omitempty"`
+	Bytes          int    `json:"bytes,omitempty"`
+}
+
+// Meta describes a page of messages.
+type Meta struct {
+	Results    int    `json:"results"`
+	Limit      int    `json:"limit"`
+	SinceID    string `json:"since_id"`
+	LastID     string `json:"last_id"`
+	Order      string `json:"order"`
+	Dropped    uint64 `json:"dropped"`
+	Next       string `json:"next"`
+	NextCursor string `json:"next_cursor"`
+	Prev       string `json:"prev"`
+	PrevCursor string `json:"prev_cursor"`
+}
+
+// MessagePage is a page of messages.
+type MessagePage struct {
+	Messages []*mailbox.Message `json:"messages"`
+	Meta     Meta               `json:"meta"`
+}
+
+// Filter selects messages by their state. Unset fields select everything; every tag must be carried.
+type Filter struct {
+	Seen    *bool
+	Flagged *bool
+	Tags    []string
+}
+
+func (f Filter) query(q url.Values) {
+	if f.Seen != nil {
+		q.Set("seen", strconv.FormatBool(*f.Seen))
+	}
+	if f.Flagged != nil {
+		q.Set("flagged", strconv.FormatBool(*f.Flagged))
+	}
+	for _, tag := range f.Tags {
+		q.Add("tag", tag)
+	}
+}
+
+// ListOptions select a page of messages. Only one of SinceID, SinceSeq, After and Before may be given.
+type ListOptions struct {
+	SinceID  string
+	SinceSeq uint64
+	After    string
+	Before   string
+	Limit    int
+
+	// Order is "asc" or "desc".
+	Order string
+
+	Filter
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	set("since_id", o.SinceID)
+	set("after", o.After)
+	set("before", o.Before)
+	set("order", o.Order)
+	if o.SinceSeq > 0 {
+		q.Set("since_seq", strconv.FormatUint(o.SinceSeq, 10))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	o.Filter.query(q)
+	return q
+}
+
+// MailboxOptions configure a new mailbox. A zero MaxMessageSize uses the server's limit.
+type MailboxOptions struct {
+	MaxMessageSize int `json:"max_message_size,omitempty"`
+}
+
+// MessageUpdate describes a change to the state of a message. Nil fields are left untouched. A non-nil Tags, even an
+// empty one, replaces the whole set of tags before AddTags and RemoveTags are applied.
+type MessageUpdate struct {
+	Seen       *bool    `json:"seen,omitempty"`
+	Flagged    *bool    `json:"flagged,omitempty"`
+	Tags       []string `json:"tags"`
+	AddTags    []string `json:"add_tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+}
+
+// BatchResult is the outcome of a batch operation on one item. Status is the HTTP status it would have had on its
+// own, and Error explains a failure.
+type BatchResult struct {
+	ID      string           `json:"id"`
+	Status  int              `json:"status"`
+	Error   string           `json:"error,omitempty"`
+	Mailbox *Mailbox         `json:"mailbox,omitempty"`
+	Message *mailbox.Message `json:"message,omitempty"`
+}
+
+// CreateMailbox creates a mailbox with a generated address.
+func (c *Client) CreateMailbox(ctx context.Context) (*Mailbox, error) {
+	return c.CreateMailboxWithOptions(ctx, MailboxOptions{})
+}
+
+// CreateMailboxWithOptions creates a mailbox with a generated address and its own limits.
+func (c *Client) CreateMailboxWithOptions(ctx context.Context, opts MailboxOptions) (*Mailbox, error) {
+	var in interface{}
+	if opts != (MailboxOptions{}) {
+		in = struct {
+			Mailbox MailboxOptions `json:"mailbox"`
+		}{opts}
+	}
+	var resp struct {
+		Mailbox *Mailbox `json:"mailbox"`
+	}
+	if err := c.do(ctx, "POST", "/mailboxes", nil, in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Mailbox, nil
+}
+
+// CreateMailboxes creates a mailbox for each of opts in one request. Either all of them are created or none are.
+func (c *Client) CreateMailboxes(ctx context.Context, opts []MailboxOptions) ([]*Mailbox, error) {
+	in := struct {
+		Mailboxes []MailboxOptions `json:"mailboxes"`
+	}{opts}
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := c.do(ctx, "POST", "/mailboxes/batch", nil, &in, &resp); err != nil {
+		return nil, err
+	}
+	boxes := make([]*Mailbox, len(resp.Results))
+	for i, r := range resp.Results {
+		boxes[i] = r.Mailbox
+	}
+	return boxes, nil
+}
+
+// ListMailboxes lists every mailbox, ordered by address.
+func (c *Client) ListMailboxes(ctx context.Context) ([]*Mailbox, error) {
+	var resp struct {
+		Mailboxes []*Mailbox `json:"mailboxes"`
+	}
+	if err := c.do(ctx, "GET", "/mailboxes", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Mailboxes, nil
+}
+
+// DeleteMailbox deletes a mailbox and its messages.
+func (c *Client) DeleteMailbox(ctx context.Context, address string) error {
+	return c.do(ctx, "DELETE", mailboxPath(address), nil, nil, nil)
+}
+
+// ListMessages fetches a page of messages.
+func (c *Client) ListMessages(ctx context.Context, address string, opts ListOptions) (*MessagePage, error) {
+	var page MessagePage
+	if err := c.do(ctx, "GET", mailboxPath(address)+"/messages", opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetMessage fetches a message.
+func (c *Client) GetMessage(ctx context.Context, address, id string) (*mailbox.Message, error) {
+	var resp struct {
+		Message *mailbox.Message `json:"message"`
+	}
+	if err := c.do(ctx, "GET", messagePath(address, id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Message, nil
+}
+
+// SendMessage delivers a message to a mailbox through the API and returns it as stored.
+func (c *Client) SendMessage(ctx context.Context, address, sender, subject, body string) (*mailbox.Message, error) {
+	var in struct {
+		Message struct {
+			Sender  string `json:"sender"`
+			Subject string `json:"subject"`
+			Body    string `json:"body"`
+		} `json:"message"`
+	}
+	in.Message.Sender, in.Message.Subject, in.Message.Body = sender, subject, body
+
+	var resp struct {
+		Message *mailbox.Message `json:"message"`
+	}
+	if err := c.do(ctx, "POST", mailboxPath(address)+"/messages", nil, &in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Message, nil
+}
+
+// ExportMailbox writes every message in a mailbox to w as an archive in one of the formats of the archive package.
+func (c *Client) ExportMailbox(ctx context.Context, address, format string, w io.Writer) error {
+	resp, err := c.doRaw(ctx, "GET", mailboxPath(address)+"/export", url.Values{"format": {format}}, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportMailbox loads the messages in an archive into a mailbox and returns their IDs, oldest first.
+func (c *Client) ImportMailbox(ctx context.Context, address, format string, archive []byte) ([]string, error) {
+	resp, err := c.doRaw(ctx, "POST", mailboxPath(address)+"/import", url.Values{"format": {format}}, "application/octet-stream", archive)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var imported struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&imported); err != nil {
+		return nil, err
+	}
+	return imported.IDs, nil
+}
+
+// UpdateMessage changes the state of a message and returns it as updated.
+func (c *Client) UpdateMessage(ctx context.Context, address, id string, u MessageUpdate) (*mailbox.Message, error) {
+	in := struct {
+		Message MessageUpdate `json:"message"`
+	}{u}
+	var resp struct {
+		Message *mailbox.Message `json:"message"`
+	}
+	if err := c.do(ctx, "PATCH", messagePath(address, id), nil, &in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Message, nil
+}
+
+// DeleteMessage deletes a message.
+func (c *Client) DeleteMessage(ctx context.Context, address, id string) error {
+	return c.do(ctx, "DELETE", messagePath(address, id), nil, nil, nil)
+}
+
+// DeleteMessages deletes a batch of messages by ID. Each result reports whether its message was found.
+func (c *Client) DeleteMessages(ctx context.Context, address string, ids []string) ([]BatchResult, error) {
+	in := struct {
+		IDs []string `json:"ids"`
+	}{ids}
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := c.do(ctx, "POST", mailboxPath(address)+"/messages/batch-delete", nil, &in, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// PurgeMessages deletes every message in a mailbox selected by f and returns them.
+func (c *Client) PurgeMessages(ctx context.Context, address string, f Filter) ([]*mailbox.Message, error) {
+	q := url.Values{}
+	f.query(q)
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := c.do(ctx, "DELETE", mailboxPath(address)+"/messages", q, nil, &resp); err != nil {
+		return nil, err
+	}
+	messages := make([]*mailbox.Message, len(resp.Results))
+	for i, r := range resp.Results {
+		messages[i] = r.Message
+	}
+	return messages, nil
+}
+
+// Wait polls a mailbox until a message newer than sinceID is matched by match, and returns it. An empty sinceID
+// considers every message in the mailbox; a nil match accepts any message. It gives up when ctx is done.
+func (c *Client) Wait(ctx context.Context, address, sinceID string, match func(*mailbox.Message) bool) (*mailbox.Message, error) {
+	it := c.Messages(address, sinceID)
+	for {
+		for it.Next(ctx) {
+			if match == nil || match(it.Message()) {
+				return it.Message(), nil
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+func mailboxPath(address string) string {
+	return "/mailboxes/" + url.PathEscape(address)
+}
+
+func messagePath(address, id string) string {
+	return mailboxPath(address) + "/messages/" + url.PathEscape(id)
+}
+
+// do sends a request, retrying it when that's safe, and decodes the response into out if it's given.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	resp, err := c.doRaw(ctx, method, path, query, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// doRaw sends a request with a body of any type, retrying it when that's safe. The caller must close the body of the
+// successful response.
+func (c *Client) doRaw(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (*http.Response, error) {
+	uri := c.BaseURL + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	idempotent := method != "POST"
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, uri, contentType, body)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		retry := false
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			retry = idempotent
+		} else {
+			apiErr := parseError(resp)
+			resp.Body.Close()
+			err = apiErr
+			// A 503 alone may come from a proxy after the API handled the request, so it's only a refusal when the
+			// API says when to come back.
+			refused := apiErr.StatusCode == http.StatusTooManyRequests ||
+				apiErr.StatusCode == http.StatusServiceUnavailable && apiErr.RetryAfter > 0
+			retry = apiErr.Temporary() && (idempotent || refused)
+			if apiErr.RetryAfter > 0 {
+				wait = apiErr.RetryAfter
+			}
+		}
+		if !retry || attempt >= c.Retries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, uri, contentType string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, uri, r)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req = req.WithContext(ctx)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
@@ -0,0 +1,342 @@
+package client_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/client"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+func newServer() (*httptest.Server, *mailbox.Registry) {
+	registry := mailbox.NewRegistry()
+	ctx := context.WithValue(context.Background(), "registry", registry)
+	return httptest.NewServer(ponyexpress.New(ctx)), registry
+}
+
+func TestMailboxes(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, err := c.CreateMailbox(ctx)
+	Equal(t, err, nil)
+	NotEqual(t, box.ID, "")
+	_, err = registry.Get(box.ID)
+	Equal(t, err, nil)
+
+	boxes, err := c.ListMailboxes(ctx)
+	Equal(t, err, nil)
+	Equal(t, len(boxes), 1)
+	Equal(t, boxes[0].ID, box.ID)
+
+	Equal(t, c.DeleteMailbox(ctx, box.ID), nil)
+	err = c.DeleteMailbox(ctx, box.ID)
+	Equal(t, client.IsNotFound(err), true)
+	MatchRegex(t, err.Error(), "unknown mailbox")
+}
+
+func TestMessages(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, err := registry.Create("a")
+	Equal(t, err, nil)
+	box.Push(&mailbox.Message{ID: "1", Sender: "a@b.c", Subject: "hello", Body: "body", Received: time.Now()})
+
+	msg, err := c.GetMessage(ctx, "a", "1")
+	Equal(t, err, nil)
+	Equal(t, msg.Subject, "hello")
+
+	page, err := c.ListMessages(ctx, "a", client.ListOptions{Limit: 10})
+	Equal(t, err, nil)
+	Equal(t, len(page.Messages), 1)
+	Equal(t, page.Meta.LastID, "1")
+
+	sent, err := c.SendMessage(ctx, "a", "d@e.f", "again", "body")
+	Equal(t, err, nil)
+	NotEqual(t, sent.ID, "")
+	Equal(t, sent.Sender, "d@e.f")
+	_, err = box.Get(sent.ID)
+	Equal(t, err, nil)
+
+	Equal(t, c.DeleteMessage(ctx, "a", "1"), nil)
+	_, err = c.GetMessage(ctx, "a", "1")
+	Equal(t, client.IsNotFound(err), true)
+}
+
+func TestCreateMailboxes(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, err := c.CreateMailboxWithOptions(ctx, client.MailboxOptions{MaxMessageSize: 1024})
+	Equal(t, err, nil)
+	Equal(t, box.MaxMessageSize, 1024)
+
+	boxes, err := c.CreateMailboxes(ctx, []client.MailboxOptions{{}, {MaxMessageSize: 2048}})
+	Equal(t, err, nil)
+	Equal(t, len(boxes), 2)
+	Equal(t, boxes[1].MaxMessageSize, 2048)
+	for _, b := range boxes {
+		_, err = registry.Get(b.ID)
+		Equal(t, err, nil)
+	}
+
+	_, err = c.CreateMailboxes(ctx, []client.MailboxOptions{{MaxMessageSize: -1}})
+	apiErr, ok := err.(*client.Error)
+	Equal(t, ok, true)
+	Equal(t, apiErr.StatusCode, http.StatusBadRequest)
+}
+
+func TestMessageState(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, _ := registry.Create("a")
+	for _, id := range []string{"1", "2", "3", "4"} {
+		box.Push(&mailbox.Message{ID: id, Sender: "a", Subject: "b", Body: "c", Received: time.Now()})
+	}
+
+	yes, no := true, false
+	msg, err := c.UpdateMessage(ctx, "a", "1", client.MessageUpdate{Seen: &yes, AddTags: []string{"inbox", "work"}})
+	Equal(t, err, nil)
+	Equal(t, msg.Seen, true)
+	Equal(t, msg.Tags, []string{"inbox", "work"})
+	msg, err = c.UpdateMessage(ctx, "a", "1", client.MessageUpdate{Tags: []string{}})
+	Equal(t, err, nil)
+	Equal(t, msg.Seen, true)
+	Equal(t, len(msg.Tags), 0)
+	_, err = c.UpdateMessage(ctx, "a", "2", client.MessageUpdate{Flagged: &yes, Tags: []string{"work"}})
+	Equal(t, err, nil)
+	_, err = c.UpdateMessage(ctx, "a", "missing", client.MessageUpdate{Seen: &yes})
+	Equal(t, client.IsNotFound(err), true)
+
+	page, err := c.ListMessages(ctx, "a", client.ListOptions{Filter: client.Filter{Seen: &no}})
+	Equal(t, err, nil)
+	Equal(t, len(page.Messages), 3)
+	page, err = c.ListMessages(ctx, "a", client.ListOptions{Filter: client.Filter{Flagged: &yes, Tags: []string{"work"}}})
+	Equal(t, err, nil)
+	Equal(t, len(page.Messages), 1)
+	Equal(t, page.Messages[0].ID, "2")
+	page, err = c.ListMessages(ctx, "a", client.ListOptions{SinceSeq: page.Messages[0].Seq, Order: "asc"})
+	Equal(t, err, nil)
+	Equal(t, len(page.Messages), 2)
+	Equal(t, page.Messages[0].ID, "3")
+
+	results, err := c.DeleteMessages(ctx, "a", []string{"3", "missing"})
+	Equal(t, err, nil)
+	Equal(t, len(results), 2)
+	Equal(t, results[0].Status, http.StatusOK)
+	Equal(t, results[1].Status, http.StatusNotFound)
+	NotEqual(t, results[1].Error, "")
+
+	purged, err := c.PurgeMessages(ctx, "a", client.Filter{Flagged: &yes})
+	Equal(t, err, nil)
+	Equal(t, len(purged), 1)
+	Equal(t, purged[0].ID, "2")
+	Equal(t, len(box.Messages()), 2)
+
+	purged, err = c.PurgeMessages(ctx, "a", client.Filter{})
+	Equal(t, err, nil)
+	Equal(t, len(purged), 2)
+	Equal(t, len(box.Messages()), 0)
+}
+
+func TestExportImport(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	a, _ := registry.Create("a")
+	registry.Create("b")
+	a.Push(&mailbox.Message{ID: "1", Sender: "a@b.c", Subject: "hello", Body: "body\n", Received: time.Now()})
+
+	var archive bytes.Buffer
+	Equal(t, c.ExportMailbox(ctx, "a", "eml-zip", &archive), nil)
+	ids, err := c.ImportMailbox(ctx, "b", "eml-zip", archive.Bytes())
+	Equal(t, err, nil)
+	Equal(t, ids, []string{"1"})
+
+	_, err = c.ImportMailbox(ctx, "b", "mbox", archive.Bytes())
+	MatchRegex(t, err.Error(), "400 invalid mbox archive")
+	err = c.ExportMailbox(ctx, "c", "mbox", &archive)
+	Equal(t, client.IsNotFound(err), true)
+}
+
+func TestIterator(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, err := registry.Create("a")
+	Equal(t, err, nil)
+	for i := 0; i < 250; i++ {
+		box.Push(&mailbox.Message{ID: fmt.Sprint(i), Sender: "a", Subject: "b", Body: "c", Received: time.Now()})
+	}
+
+	it := c.Messages("a", "")
+	var ids []string
+	for it.Next(ctx) {
+		ids = append(ids, it.Message().ID)
+	}
+	Equal(t, it.Err(), nil)
+	Equal(t, len(ids), 250)
+	Equal(t, ids[0], "0")
+	Equal(t, ids[249], "249")
+
+	// Iteration picks up where it left off.
+	box.Push(&mailbox.Message{ID: "250", Sender: "a", Subject: "b", Body: "c", Received: time.Now()})
+	Equal(t, it.Next(ctx), true)
+	Equal(t, it.Message().ID, "250")
+	Equal(t, it.Next(ctx), false)
+
+	it = c.Messages("missing", "")
+	Equal(t, it.Next(ctx), false)
+	Equal(t, client.IsNotFound(it.Err()), true)
+}
+
+func TestIteratorLastSeenDeleted(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	box, _ := registry.Create("a")
+	push := func(id string) {
+		box.Push(&mailbox.Message{ID: id, Sender: "a", Subject: "b", Body: "c", Received: time.Now()})
+	}
+	push("a")
+	push("b")
+	push("c")
+
+	it := c.Messages("a", "")
+	var ids []string
+	for it.Next(ctx) {
+		ids = append(ids, it.Message().ID)
+	}
+
+	// The last message seen disappears before the next one arrives.
+	box.Remove("c")
+	push("d")
+	for it.Next(ctx) {
+		ids = append(ids, it.Message().ID)
+	}
+	Equal(t, it.Err(), nil)
+	Equal(t, ids, []string{"a", "b", "c", "d"})
+}
+
+func TestWait(t *testing.T) {
+	server, registry := newServer()
+	defer server.Close()
+	defer registry.Close()
+	c := client.New(server.URL)
+	c.PollInterval = 10 * time.Millisecond
+
+	box, err := registry.Create("a")
+	Equal(t, err, nil)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		box.Push(&mailbox.Message{ID: "1", Sender: "a", Subject: "ignored", Body: "c", Received: time.Now()})
+		box.Push(&mailbox.Message{ID: "2", Sender: "a", Subject: "wanted", Body: "c", Received: time.Now()})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := c.Wait(ctx, "a", "", func(m *mailbox.Message) bool { return m.Subject == "wanted" })
+	Equal(t, err, nil)
+	Equal(t, msg.ID, "2")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Wait(ctx, "a", "2", nil)
+	Equal(t, err, context.DeadlineExceeded)
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "try again"}`))
+			return
+		}
+		w.Write([]byte(`{"message": {"id": "1"}}`))
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL)
+	c.RetryWait = time.Millisecond
+	msg, err := c.GetMessage(context.Background(), "a", "1")
+	Equal(t, err, nil)
+	Equal(t, msg.ID, "1")
+	Equal(t, atomic.LoadInt32(&calls), int32(3))
+
+	c.Retries = 0
+	atomic.StoreInt32(&calls, 0)
+	_, err = c.GetMessage(context.Background(), "a", "1")
+	apiErr, ok := err.(*client.Error)
+	Equal(t, ok, true)
+	Equal(t, apiErr.StatusCode, http.StatusServiceUnavailable)
+	Equal(t, apiErr.Message, "try again")
+	Equal(t, apiErr.Temporary(), true)
+}
+
+func TestRetryCreate(t *testing.T) {
+	var calls int32
+	var header func(http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			header(w.Header())
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "try again"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"message": {"id": "1"}}`))
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL)
+	c.RetryWait = time.Millisecond
+
+	// A bare 503 may have come after the message was stored, so sending it again could deliver it twice.
+	header = func(http.Header) {}
+	_, err := c.SendMessage(context.Background(), "a", "brett@buddin.us", "subject", "body")
+	apiErr, ok := err.(*client.Error)
+	Equal(t, ok, true)
+	Equal(t, apiErr.StatusCode, http.StatusServiceUnavailable)
+	Equal(t, atomic.LoadInt32(&calls), int32(1))
+
+	// With Retry-After the API refused the request before handling it.
+	atomic.StoreInt32(&calls, 0)
+	header = func(h http.Header) { h.Set("Retry-After", "1") }
+	msg, err := c.SendMessage(context.Background(), "a", "brett@buddin.us", "subject", "body")
+	Equal(t, err, nil)
+	Equal(t, msg.ID, "1")
+	Equal(t, atomic.LoadInt32(&calls), int32(2))
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+
+	// Message is the error reported by the API, or the status text when there was none.
+	Message string
+
+	// RetryAfter is how long the API asked callers to wait before retrying, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ponyexpress: %d %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if it's retried.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether err is the API saying a mailbox or message doesn't exist.
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsTooLarge reports whether err is the API refusing a message for its size.
+func IsTooLarge(err error) bool {
+	return statusCode(err) == http.StatusRequestEntityTooLarge
+}
+
+// IsRateLimited reports whether err is the API refusing a request for arriving too quickly.
+func IsRateLimited(err error) bool {
+	return statusCode(err) == http.StatusTooManyRequests
+}
+
+func statusCode(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// parseError builds an Error from a response, reading the message from the API's {"error": "..."} body.
+func parseError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return e
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(buf, &body) == nil && body.Error != "" {
+		e.Message = body.Error
+	}
+	return e
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// pageSize is how many messages an iterator asks for at once.
+const pageSize = 100
+
+// MessageIterator walks the messages of a mailbox from oldest to newest, fetching pages as needed. After the first
+// page it continues from the cursor the API returns rather than from the ID of the last message, so messages that
+// are deleted or evicted meanwhile don't make it start over.
+//
+//	it := c.Messages(address, "")
+//	for it.Next(ctx) {
+//		msg := it.Message()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Next returns false once it has caught up. Calling it again later picks up messages that have arrived since.
+type MessageIterator struct {
+	client  *Client
+	address string
+	sinceID string
+	after   string
+	buf     []*mailbox.Message
+	current *mailbox.Message
+	err     error
+}
+
+// Messages iterates over the messages in a mailbox newer than sinceID. An empty sinceID starts from the oldest
+// message.
+func (c *Client) Messages(address, sinceID string) *MessageIterator {
+	return &MessageIterator{client: c, address: address, sinceID: sinceID}
+}
+
+// Next advances to the next message, fetching another page when needed. It returns false when there are no more
+// messages for now or an error occurred.
+func (it *MessageIterator) Next(ctx context.Context) bool {
+	it.err = nil
+	if len(it.buf) == 0 {
+		opts := ListOptions{Limit: pageSize, Order: "asc"}
+		if it.after != "" {
+			opts.After = it.after
+		} else {
+			opts.SinceID = it.sinceID
+		}
+		page, err := it.client.ListMessages(ctx, it.address, opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if len(page.Messages) > 0 {
+			it.after = page.Meta.NextCursor
+		}
+		it.buf = page.Messages
+	}
+	if len(it.buf) == 0 {
+		return false
+	}
+
+	it.current, it.buf = it.buf[0], it.buf[1:]
+	it.sinceID = it.current.ID
+	return true
+}
+
+// Message returns the message Next advanced to.
+func (it *MessageIterator) Message() *mailbox.Message {
+	return it.current
+}
+
+// SinceID is the ID of the last message returned, for starting a new iterator where this one left off.
+func (it *MessageIterator) SinceID() string {
+	return it.sinceID
+}
+
+// Err returns the error that stopped the last call to Next, if any.
+func (it *MessageIterator) Err() error {
+	return it.err
+}
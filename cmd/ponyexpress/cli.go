@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/archive"
+	"github.com/brettbuddin/ponyexpress/client"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// Output formats.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// command is a subcommand talking to a running ponyexpress through its API.
+type command struct {
+	usage string
+
+	// flags defines the subcommand's own flags, if it has any.
+	flags func(c *cli, fs *flag.FlagSet)
+
+	run func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"mailbox": {
+		"create": {"", nil, mailboxCreate},
+		"delete": {"ADDRESS...", nil, mailboxDelete},
+		"list":   {"", nil, mailboxList},
+		"export": {"ADDRESS [FILE]", archiveFlags, mailboxExport},
+		"import": {"FILE [ADDRESS]", archiveFlags, mailboxImport},
+	},
+	"messages": {
+		"list":   {"ADDRESS", messagesListFlags, messagesList},
+		"show":   {"ADDRESS ID", nil, messagesShow},
+		"raw":    {"ADDRESS ID", nil, messagesRaw},
+		"delete": {"ADDRESS ID...", nil, messagesDelete},
+	},
+	"tail": {
+		"": {"ADDRESS", tailFlags, tail},
+	},
+	"send": {
+		"": {"", sendFlags, send},
+	},
+}
+
+// cli holds what the subcommands need, including the values of their flags.
+type cli struct {
+	client   *client.Client
+	output   string
+	stdout   io.Writer
+	insecure bool
+	timeout  time.Duration
+
+	limit    int
+	since    string
+	all      bool
+	interval time.Duration
+	format   string
+
+	message      composition
+	eml          string
+	smtp         string
+	smtpUser     string
+	smtpPassword string
+}
+
+// isCommand reports whether args name a subcommand rather than flags for the server.
+func isCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	_, ok := commands[args[0]]
+	return ok
+}
+
+// runCommand runs a subcommand and returns the process exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	group := commands[args[0]]
+	name, rest := "", args[1:]
+	if _, ok := group[""]; !ok {
+		if len(rest) == 0 || group[rest[0]].run == nil {
+			fmt.Fprintf(stderr, "usage: ponyexpress %s {%s} [flags]\n", args[0], strings.Join(names(group), "|"))
+			return 2
+		}
+		name, rest = rest[0], rest[1:]
+	}
+	cmd := group[name]
+	title := strings.TrimSpace("ponyexpress " + args[0] + " " + name)
+
+	fs := flag.NewFlagSet(title, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", serverDefault(), "address of the ponyexpress API (or set PONYEXPRESS_SERVER)")
+	output := fs.String("o", outputTable, "output format: table or json")
+	insecure := fs.Bool("insecure", false, "skip verification of the server's TLS certificate")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up on requests after this long")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s [flags] %s\n", title, cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	c := &cli{stdout: stdout}
+	if cmd.flags != nil {
+		cmd.flags(c, fs)
+	}
+	if err := fs.Parse(rest); err != nil {
+		return 2
+	}
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(stderr, "unknown output format: %s\n", *output)
+		return 2
+	}
+
+	c.output = *output
+	c.insecure = *insecure
+	c.timeout = *timeout
+	c.client = client.New(*server)
+	c.client.HTTPClient = &http.Client{Timeout: *timeout}
+	if *insecure {
+		c.client.HTTPClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := cmd.run(c, ctx, fs.Args()); err != nil {
+		if err == errUsage {
+			fs.Usage()
+			return 2
+		}
+		if err == context.Canceled {
+			return 0
+		}
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func names(group map[string]command) []string {
+	var names []string
+	for _, name := range []string{"create", "list", "show", "raw", "delete", "export", "import"} {
+		if _, ok := group[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func serverDefault() string {
+	if s := os.Getenv("PONYEXPRESS_SERVER"); s != "" {
+		return s
+	}
+	return "http://localhost:3000"
+}
+
+// readFile reads a file, or stdin when path is "-".
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// errUsage is returned by subcommands given the wrong arguments.
+var errUsage = fmt.Errorf("usage")
+
+func (c *cli) json(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *cli) table(header string, rows [][]interface{}) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprint(cell)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func mailboxCreate(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	box, err := c.client.CreateMailbox(ctx)
+	if err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.json(box)
+	}
+	_, err = fmt.Fprintln(c.stdout, box.ID)
+	return err
+}
+
+func mailboxDelete(c *cli, ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	for _, address := range args {
+		if err := c.client.DeleteMailbox(ctx, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mailboxList(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	boxes, err := c.client.ListMailboxes(ctx)
+	if err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.json(boxes)
+	}
+	rows := make([][]interface{}, len(boxes))
+	for i, box := range boxes {
+		rows[i] = []interface{}{box.ID, box.Messages, box.Bytes, box.MaxMessageSize}
+	}
+	return c.table("ADDRESS\tMESSAGES\tBYTES\tMAX MESSAGE SIZE", rows)
+}
+
+func archiveFlags(c *cli, fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", "", "archive format: mbox, maildir-tar or eml-zip (default from the file name, or mbox)")
+}
+
+// archiveFormat picks the archive format given by --format, or the one matching a file's extension.
+func (c *cli) archiveFormat(file string) (*archive.Format, error) {
+	if c.format != "" {
+		return archive.Lookup(c.format)
+	}
+	if format, ok := archive.ForFile(file); ok {
+		return format, nil
+	}
+	return archive.Lookup(archive.MBox)
+}
+
+// mailboxExport writes a mailbox's messages to a file, or to stdout.
+func mailboxExport(c *cli, ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	file := "-"
+	if len(args) == 2 {
+		file = args[1]
+	}
+	format, err := c.archiveFormat(file)
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		return c.client.ExportMailbox(ctx, args[0], format.Name, c.stdout)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := c.client.ExportMailbox(ctx, args[0], format.Name, f); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// mailboxImport loads an archive, or stdin, into a mailbox. Without an address, a new mailbox is created and its
+// address printed.
+func mailboxImport(c *cli, ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	format, err := c.archiveFormat(args[0])
+	if err != nil {
+		return err
+	}
+	content, err := readFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	address := ""
+	if len(args) == 2 {
+		address = args[1]
+	} else {
+		box, err := c.client.CreateMailbox(ctx)
+		if err != nil {
+			return err
+		}
+		address = box.ID
+	}
+	ids, err := c.client.ImportMailbox(ctx, address, format.Name, content)
+	if err != nil {
+		return err
+	}
+
+	if c.output == outputJSON {
+		return c.json(map[string]interface{}{"mailbox": address, "ids": ids})
+	}
+	if len(args) == 1 {
+		fmt.Fprintln(c.stdout, address)
+	}
+	return nil
+}
+
+func messagesListFlags(c *cli, fs *flag.FlagSet) {
+	fs.IntVar(&c.limit, "limit", 50, "number of messages to list")
+	fs.StringVar(&c.since, "since", "", "only list messages newer than this message ID")
+}
+
+func messagesList(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	opts := client.ListOptions{Limit: c.limit, Order: "desc", SinceID: c.since}
+	page, err := c.client.ListMessages(ctx, args[0], opts)
+	if err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.json(page.Messages)
+	}
+	rows := make([][]interface{}, len(page.Messages))
+	for i, msg := range page.Messages {
+		rows[i] = []interface{}{msg.ID, msg.Received.Local().Format(time.RFC3339), msg.Sender, msg.Subject, flags(msg)}
+	}
+	return c.table("ID\tRECEIVED\tFROM\tSUBJECT\tFLAGS", rows)
+}
+
+// flags summarizes the state of a message: N for new (unseen) and F for flagged.
+func flags(msg *mailbox.Message) string {
+	s := ""
+	if !msg.Seen {
+		s += "N"
+	}
+	if msg.Flagged {
+		s += "F"
+	}
+	return s
+}
+
+func messagesShow(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	msg, err := c.client.GetMessage(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.json(msg)
+	}
+	fmt.Fprintf(c.stdout, "ID:       %s\n", msg.ID)
+	fmt.Fprintf(c.stdout, "From:     %s\n", msg.Sender)
+	fmt.Fprintf(c.stdout, "Subject:  %s\n", msg.Subject)
+	fmt.Fprintf(c.stdout, "Received: %s\n", msg.Received.Local().Format(time.RFC1123Z))
+	if len(msg.Tags) > 0 {
+		fmt.Fprintf(c.stdout, "Tags:     %s\n", strings.Join(msg.Tags, ", "))
+	}
+	_, err = fmt.Fprintf(c.stdout, "\n%s\n", msg.Body)
+	return err
+}
+
+// messagesRaw writes a message's body exactly as it was received.
+func messagesRaw(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	msg, err := c.client.GetMessage(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(c.stdout, msg.Body)
+	return err
+}
+
+func messagesDelete(c *cli, ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, id := range args[1:] {
+		if err := c.client.DeleteMessage(ctx, args[0], id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tailFlags(c *cli, fs *flag.FlagSet) {
+	fs.BoolVar(&c.all, "all", false, "print the messages already in the mailbox first")
+	fs.DurationVar(&c.interval, "interval", time.Second, "how often to check for new messages")
+}
+
+// tail prints messages as they arrive in a mailbox until it's interrupted.
+func tail(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	address := args[0]
+
+	sinceID := ""
+	if !c.all {
+		page, err := c.client.ListMessages(ctx, address, client.ListOptions{Order: "desc", Limit: 1})
+		if err != nil {
+			return err
+		}
+		sinceID = page.Meta.LastID
+	}
+
+	encoder := json.NewEncoder(c.stdout)
+	it := c.client.Messages(address, sinceID)
+	for {
+		for it.Next(ctx) {
+			msg := it.Message()
+			if c.output == outputJSON {
+				encoder.Encode(msg)
+				continue
+			}
+			fmt.Fprintf(c.stdout, "%s  %s  %s  %s\n", msg.Received.Local().Format(time.RFC3339), msg.ID, msg.Sender, msg.Subject)
+		}
+		if err := it.Err(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.interval):
+		}
+	}
+}
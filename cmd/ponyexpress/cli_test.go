@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/ponyexpresstest"
+)
+
+// run runs a subcommand against px, passing --server after the subcommand's name.
+func run(px *ponyexpresstest.Server, args ...string) (int, string, string) {
+	n := 2
+	if _, ok := commands[args[0]][""]; ok {
+		n = 1
+	}
+	full := append(append(append([]string{}, args[:n]...), "--server", px.URL), args[n:]...)
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand(full, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestMailboxCommands(t *testing.T) {
+	px := ponyexpresstest.New(t)
+
+	code, out, _ := run(px, "mailbox", "create")
+	Equal(t, code, 0)
+	address := strings.TrimSpace(out)
+	_, err := px.Registry.Get(address)
+	Equal(t, err, nil)
+
+	code, out, _ = run(px, "mailbox", "list")
+	Equal(t, code, 0)
+	MatchRegex(t, out, `^ADDRESS\s+MESSAGES\s+BYTES\s+MAX MESSAGE SIZE\n`+address+`\s+0\s+0\s+\d+\n$`)
+
+	code, out, _ = run(px, "mailbox", "list", "-o", "json")
+	Equal(t, code, 0)
+	var boxes []map[string]interface{}
+	Equal(t, json.Unmarshal([]byte(out), &boxes), nil)
+	Equal(t, boxes[0]["id"], address)
+
+	code, _, _ = run(px, "mailbox", "delete", address)
+	Equal(t, code, 0)
+	code, _, errOut := run(px, "mailbox", "delete", address)
+	Equal(t, code, 1)
+	MatchRegex(t, errOut, "404 unknown mailbox")
+
+	code, _, errOut = run(px, "mailbox", "frobnicate")
+	Equal(t, code, 2)
+	MatchRegex(t, errOut, `usage: ponyexpress mailbox \{create\|list\|delete\|export\|import\}`)
+}
+
+func TestMessagesCommands(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	box, err := px.Registry.Create("a")
+	Equal(t, err, nil)
+	box.Push(&mailbox.Message{ID: "1", Sender: "brett@buddin.us", Subject: "Hello", Body: "Hi there", Received: time.Now()})
+
+	code, out, _ := run(px, "messages", "list", "a")
+	Equal(t, code, 0)
+	MatchRegex(t, out, `(?m)^1\s+\S+\s+brett@buddin.us\s+Hello\s+N$`)
+
+	code, out, _ = run(px, "messages", "show", "a", "1")
+	Equal(t, code, 0)
+	MatchRegex(t, out, "Subject:  Hello\n")
+	MatchRegex(t, out, "\n\nHi there\n$")
+
+	code, out, _ = run(px, "messages", "raw", "a", "1")
+	Equal(t, code, 0)
+	Equal(t, out, "Hi there")
+
+	code, _, errOut := run(px, "messages", "show", "a")
+	Equal(t, code, 2)
+	MatchRegex(t, errOut, "usage: ponyexpress messages show \\[flags\\] ADDRESS ID")
+
+	code, _, _ = run(px, "messages", "delete", "a", "1")
+	Equal(t, code, 0)
+	_, err = box.Get("1")
+	NotEqual(t, err, nil)
+}
+
+func TestExportImportCommands(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	box, err := px.Registry.Create("a")
+	Equal(t, err, nil)
+	received := time.Now().Add(-time.Minute).Truncate(time.Second)
+	box.Push(&mailbox.Message{ID: "1", Sender: "brett@buddin.us", Subject: "Hello", Body: "Hi there\n", Received: received})
+
+	file := filepath.Join(t.TempDir(), "a.tar")
+	code, _, errOut := run(px, "mailbox", "export", "a", file)
+	Equal(t, errOut, "")
+	Equal(t, code, 0)
+
+	code, out, errOut := run(px, "mailbox", "import", file)
+	Equal(t, errOut, "")
+	Equal(t, code, 0)
+	imported, err := px.Registry.Get(strings.TrimSpace(out))
+	Equal(t, err, nil)
+	msg, err := imported.Get("1")
+	Equal(t, err, nil)
+	Equal(t, msg.Received.Equal(received), true)
+
+	code, _, errOut = run(px, "mailbox", "import", "--format", "mbox", file, "a")
+	Equal(t, code, 1)
+	MatchRegex(t, errOut, "invalid mbox archive")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// composition is a message put together from the send subcommand's flags.
+type composition struct {
+	from        string
+	to          []string
+	subject     string
+	text        string
+	html        string
+	attachments []string
+	headers     []string
+}
+
+// build encodes the message as RFC 5322 text. Plain text is sent as is; HTML makes it multipart/alternative and
+// attachments wrap that in multipart/mixed.
+func (c *composition) build() ([]byte, error) {
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", c.from)
+	if len(c.to) > 0 {
+		header.Set("To", strings.Join(c.to, ", "))
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", c.subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-Id", messageID(c.from))
+	header.Set("Mime-Version", "1.0")
+	for _, h := range c.headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("header must be given as Name: value: %s", h)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+
+	var parts []part
+	if c.html == "" {
+		parts = append(parts, textPart("text/plain", c.text))
+	} else {
+		alternatives := []part{textPart("text/html", c.html)}
+		if c.text != "" {
+			alternatives = append([]part{textPart("text/plain", c.text)}, alternatives...)
+		}
+		parts = append(parts, multipartPart("alternative", alternatives))
+	}
+	for _, path := range c.attachments {
+		p, err := attachmentPart(path)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+
+	body := parts[0]
+	if len(parts) > 1 {
+		body = multipartPart("mixed", parts)
+	}
+	for k, v := range body.header {
+		header[k] = v
+	}
+	writeHeader(&buf, header)
+	buf.WriteString("\r\n")
+	buf.Write(body.body)
+	return buf.Bytes(), nil
+}
+
+// part is an encoded MIME part.
+type part struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func textPart(contentType, text string) part {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	io.WriteString(w, strings.Replace(text, "\n", "\r\n", -1))
+	w.Close()
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: buf.Bytes(),
+	}
+}
+
+func multipartPart(subtype string, parts []part) part {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		pw, _ := w.CreatePart(p.header)
+		pw.Write(p.body)
+	}
+	w.Close()
+	return part{
+		header: textproto.MIMEHeader{"Content-Type": {"multipart/" + subtype + "; boundary=" + w.Boundary()}},
+		body:   buf.Bytes(),
+	}
+}
+
+func attachmentPart(path string) (part, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return part{}, err
+	}
+	name := filepath.Base(path)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			"Content-Transfer-Encoding": {"base64"},
+		},
+		body: buf.Bytes(),
+	}, nil
+}
+
+// writeHeader writes header fields in a stable order: the usual ones first, then the rest alphabetically.
+func writeHeader(w io.Writer, header textproto.MIMEHeader) {
+	first := []string{"From", "To", "Subject", "Date", "Message-Id", "Mime-Version"}
+	written := map[string]bool{}
+	for _, k := range first {
+		for _, v := range header[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+		written[k] = true
+	}
+	var rest []string
+	for k := range header {
+		if !written[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		for _, v := range header[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(addr.Address, "@"); i >= 0 {
+			domain = addr.Address[i+1:]
+		}
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%x@%s>", b, domain)
+}
@@ -1,40 +1,295 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
 
 	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/api"
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/fixtures"
 	"github.com/brettbuddin/ponyexpress/logger"
 	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/ratelimit"
 )
 
-const timeout = 5 * time.Second
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// flagSetting is a command-line flag that overrides a setting. Boolean flags may be given bare, as in --test-mode.
+type flagSetting struct {
+	name    string
+	setting string
+	usage   string
+	boolean bool
+}
+
+var flagSettings = []flagSetting{
+	{name: "http-addr", setting: "http.addr", usage: "address to listen on, as host:port"},
+	{name: "http-read-timeout", setting: "http.read_timeout", usage: "longest time to read a request, such as 30s"},
+	{name: "http-write-timeout", setting: "http.write_timeout", usage: "longest time to write a response"},
+	{name: "shutdown-timeout", setting: "http.shutdown_timeout", usage: "how long in-flight requests may run after a shutdown signal"},
+	{name: "tls-cert", setting: "http.tls.cert_file", usage: "PEM certificate file to serve HTTPS with"},
+	{name: "tls-key", setting: "http.tls.key_file", usage: "PEM private key file for --tls-cert"},
+	{name: "tls-self-signed", setting: "http.tls.self_signed", usage: "serve HTTPS with a throwaway certificate for localhost", boolean: true},
+	{name: "tls-client-ca", setting: "http.tls.client_ca_file", usage: "PEM file of CAs that client certificates are verified against"},
+	{name: "tls-client-auth", setting: "http.tls.client_auth", usage: "client certificate policy: none, request or require"},
+	{name: "cors-origins", setting: "http.cors.allowed_origins", usage: "comma-separated origins allowed to make cross-origin requests"},
+	{name: "cors-methods", setting: "http.cors.allowed_methods", usage: "comma-separated methods allowed in cross-origin requests"},
+	{name: "cors-headers", setting: "http.cors.allowed_headers", usage: "comma-separated headers allowed in cross-origin requests"},
+	{name: "cors-expose", setting: "http.cors.exposed_headers", usage: "comma-separated response headers exposed to cross-origin callers"},
+	{name: "cors-credentials", setting: "http.cors.allow_credentials", usage: "allow cross-origin requests to carry credentials", boolean: true},
+	{name: "cors-max-age", setting: "http.cors.max_age", usage: "how long browsers may cache a preflight response"},
+	{name: "rate-limit", setting: "http.rate_limit.rate", usage: "requests per second each caller may make; 0 disables the limit"},
+	{name: "rate-limit-burst", setting: "http.rate_limit.burst", usage: "requests each caller may make at once"},
+	{name: "inbound-rate-limit", setting: "limits.inbound_rate", usage: "messages per second each mailbox accepts; 0 disables the limit"},
+	{name: "inbound-rate-limit-burst", setting: "limits.inbound_burst", usage: "messages each mailbox accepts at once"},
+	{name: "expire-after", setting: "retention.expire_after", usage: "how long messages are kept, such as 1h"},
+	{name: "size-limit", setting: "retention.size_limit", usage: "most messages kept in each mailbox"},
+	{name: "evict-every", setting: "retention.evict_every", usage: "how often expired messages are evicted"},
+	{name: "dirty-max", setting: "retention.dirty_max", usage: "changed mailboxes that trigger an eviction before the next --evict-every"},
+	{name: "max-message-size", setting: "limits.max_message_size", usage: "largest message accepted, in bytes"},
+	{name: "log-level", setting: "log.level", usage: "log level: debug, info, warn or error"},
+	{name: "log-format", setting: "log.format", usage: "log format: logfmt or json"},
+	{name: "log-levels", setting: "log.subsystems", usage: "comma-separated subsystem=level overrides, such as http=debug"},
+	{name: "fixtures", setting: "fixtures.path", usage: "JSON file of mailboxes and messages to load at startup"},
+	{name: "test-mode", setting: "testing.enabled", usage: "enable the /admin/clock endpoints for end-to-end tests", boolean: true},
+}
 
 func main() {
-	registry := mailbox.NewRegistry()
+	if isCommand(os.Args[1:]) {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	cfg, printConfig, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(cfg)
+		return
+	}
+	cfg.Apply()
+	reloader := config.NewReloader(cfg, func() (config.Config, error) {
+		cfg, _, err := loadConfig(os.Args[1:])
+		return cfg, err
+	})
+
+	readiness := api.NewReadiness("listener", "storage")
+	var clock mailbox.Clock = mailbox.SystemClock
+	if cfg.Testing.Enabled {
+		logger.Infof("Test mode enabled: time can be controlled through /admin/clock")
+		clock = mailbox.NewManualClock()
+	}
+	registry := mailbox.NewRegistryWithClock(clock)
+	loader := fixtures.NewLoader(registry, func() string { return reloader.Current().Fixtures.Path })
+	if cfg.Fixtures.Path != "" {
+		summary, err := loader.Load()
+		if err != nil {
+			logger.Errorf("fixtures: %s", err)
+			os.Exit(1)
+		}
+		logger.Infof("Loaded %d messages into %d mailboxes from %s", summary.Messages, summary.Mailboxes, cfg.Fixtures.Path)
+	}
+	readiness.Set("storage", true)
+
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, "registry", registry)
+	ctx = context.WithValue(ctx, "reloader", reloader)
+	ctx = context.WithValue(ctx, "readiness", readiness)
+	ctx = context.WithValue(ctx, "fixtures", loader)
+	if manual, ok := clock.(*mailbox.ManualClock); ok {
+		ctx = context.WithValue(ctx, "clock", manual)
+	}
+	ctx = context.WithValue(ctx, "build", api.Build{
+		Version:  version,
+		Commit:   commit,
+		Started:  time.Now(),
+		Features: features(cfg),
+	})
+	if cfg.HTTP.CORS.Enabled() {
+		ctx = context.WithValue(ctx, "cors", cfg.HTTP.CORS.Options())
+	}
+	// The limiter is installed even when disabled so that a reload can turn it on.
+	limiter := ratelimit.New(cfg.HTTP.RateLimit.Rate, cfg.HTTP.RateLimit.Burst)
+	reloader.OnReload(func(cfg config.Config) {
+		limiter.SetLimits(cfg.HTTP.RateLimit.Rate, cfg.HTTP.RateLimit.Burst)
+	})
+	ctx = context.WithValue(ctx, "rate_limiter", limiter)
 	app := ponyexpress.New(ctx)
 
 	// TODO
 	// go serveSMTP(registry)
 
-	addr := os.Getenv("HTTP_ADDR")
-	if addr == "" {
-		addr = ":3000"
-	}
-	logger.Infof("Listening at http://localhost%s", addr)
 	server := &http.Server{
-		ReadTimeout:  timeout,
-		WriteTimeout: timeout,
-		Addr:         addr,
+		ReadTimeout:  time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.HTTP.WriteTimeout),
+		Addr:         cfg.HTTP.Addr,
 		Handler:      app,
 	}
-	if err := server.ListenAndServe(); err != nil {
-		logger.Errorf(err.Error())
+
+	if cfg.HTTP.TLS.Enabled() {
+		tlsConfig, err := cfg.HTTP.TLS.ServerConfig(cfg.HTTP.Addr)
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+		if err := http2.ConfigureServer(server, nil); err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+	}
+
+	listener, err := net.Listen("tcp", cfg.HTTP.Addr)
+	if err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	readiness.Set("listener", true)
+
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			logger.Infof("Listening at https://localhost%s", cfg.HTTP.Addr)
+			errs <- server.ServeTLS(listener, "", "")
+			return
+		}
+		logger.Infof("Listening at http://localhost%s", cfg.HTTP.Addr)
+		errs <- server.Serve(listener)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case err := <-errs:
+			logger.Errorf("%s", err)
+			registry.Close()
+			os.Exit(1)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reloader.Reload()
+				continue
+			}
+			logger.Infof("Received %s, shutting down", sig)
+			readiness.Set("listener", false)
+			break wait
+		}
+	}
+
+	if err := shutdown(server, registry, time.Duration(cfg.HTTP.ShutdownTimeout)); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	logger.Infof("Shutdown complete")
+}
+
+// features lists the optional features enabled by a configuration.
+func features(cfg config.Config) []string {
+	features := []string{"metrics", "reload"}
+	if cfg.HTTP.TLS.Enabled() {
+		features = append(features, "tls", "http2")
+	}
+	if cfg.HTTP.TLS.ClientAuth != config.ClientAuthNone {
+		features = append(features, "client_certificates")
+	}
+	if cfg.HTTP.CORS.Enabled() {
+		features = append(features, "cors")
+	}
+	if cfg.HTTP.RateLimit.Rate > 0 {
+		features = append(features, "rate_limit")
+	}
+	if cfg.Limits.InboundRate > 0 {
+		features = append(features, "inbound_rate_limit")
+	}
+	if cfg.Fixtures.Path != "" {
+		features = append(features, "fixtures")
+	}
+	if cfg.Testing.Enabled {
+		features = append(features, "test_mode")
+	}
+	return features
+}
+
+// loadConfig builds the configuration from defaults, the config file, the environment and finally the command-line
+// flags, in that order of precedence.
+func loadConfig(args []string) (config.Config, bool, error) {
+	cfg := config.Default()
+
+	fs := flag.NewFlagSet("ponyexpress", flag.ContinueOnError)
+	path := fs.String("config", "", "path to a JSON config file")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+
+	settings := map[string]string{}
+	for _, f := range flagSettings {
+		settings[f.name] = f.setting
+		if f.boolean {
+			fs.Bool(f.name, false, f.usage)
+		} else {
+			fs.String(f.name, "", f.usage)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, false, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if *path != "" {
+		if err := cfg.LoadFile(*path); err != nil {
+			return cfg, false, err
+		}
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return cfg, false, err
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		key, ok := settings[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		err = cfg.Set(key, f.Value.String())
+	})
+	if err != nil {
+		return cfg, false, err
+	}
+
+	return cfg, *printConfig, cfg.Validate()
+}
+
+// shutdown stops accepting connections, waits up to a deadline for in-flight requests to finish and then stops the
+// Registry. Connections still open after the deadline are closed.
+func shutdown(server *http.Server, registry *mailbox.Registry, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
 	}
+	registry.Close()
+	return err
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+const defaultSender = "ponyexpress@localhost"
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func sendFlags(c *cli, fs *flag.FlagSet) {
+	m := &c.message
+	fs.StringVar(&m.from, "from", "", "sender address (default "+defaultSender+")")
+	fs.Var((*stringList)(&m.to), "to", "recipient: a mailbox address for the API, an email address for SMTP (repeatable)")
+	fs.StringVar(&m.subject, "subject", "", "subject")
+	fs.StringVar(&m.text, "text", "", "plain text body, or @FILE to read it from a file")
+	fs.StringVar(&m.html, "html", "", "HTML body, or @FILE to read it from a file")
+	fs.Var((*stringList)(&m.attachments), "attach", "file to attach (repeatable, SMTP only)")
+	fs.Var((*stringList)(&m.headers), "header", `extra header as "Name: value" (repeatable, SMTP only)`)
+	fs.StringVar(&c.eml, "eml", "", "send this RFC 5322 message file (- for stdin) instead of composing one")
+	fs.StringVar(&c.smtp, "smtp", "", "deliver over SMTP to this host:port instead of through the API")
+	fs.StringVar(&c.smtpUser, "smtp-user", "", "SMTP username, for servers that require authentication")
+	fs.StringVar(&c.smtpPassword, "smtp-password", "", "SMTP password")
+}
+
+// send delivers a composed message, or one read from a file, through the API or over SMTP.
+func send(c *cli, ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	m := &c.message
+	for _, body := range []*string{&m.text, &m.html} {
+		if strings.HasPrefix(*body, "@") {
+			content, err := ioutil.ReadFile((*body)[1:])
+			if err != nil {
+				return err
+			}
+			*body = string(content)
+		}
+	}
+
+	var raw []byte
+	if c.eml != "" {
+		var err error
+		if raw, err = readFile(c.eml); err != nil {
+			return err
+		}
+	} else if m.from == "" {
+		m.from = defaultSender
+	}
+
+	if c.smtp != "" {
+		return c.sendSMTP(ctx, raw)
+	}
+	return c.sendAPI(ctx, raw)
+}
+
+// sendAPI delivers the message to each mailbox in --to. The API stores a sender, subject and body only, so composed
+// messages with more than that are refused; a message file's body is stored as it appears in the file.
+func (c *cli) sendAPI(ctx context.Context, raw []byte) error {
+	m := &c.message
+	if len(m.to) == 0 {
+		return fmt.Errorf("--to is required")
+	}
+
+	sender, subject, body := m.from, m.subject, m.text
+	if raw != nil {
+		parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadAll(parsed.Body)
+		if err != nil {
+			return err
+		}
+		if sender == "" {
+			sender = parsed.Header.Get("From")
+		}
+		if subject == "" {
+			subject = decodeHeader(parsed.Header.Get("Subject"))
+		}
+		body = string(content)
+	} else {
+		if len(m.attachments) > 0 || len(m.headers) > 0 || (m.text != "" && m.html != "") {
+			return fmt.Errorf("attachments, extra headers and text with HTML alternatives can only be sent with --smtp")
+		}
+		if m.html != "" {
+			body = m.html
+		}
+	}
+
+	var sent []*mailbox.Message
+	for _, address := range m.to {
+		msg, err := c.client.SendMessage(ctx, address, sender, subject, body)
+		if err != nil {
+			return fmt.Errorf("%s: %s", address, err)
+		}
+		sent = append(sent, msg)
+	}
+
+	if c.output == outputJSON {
+		return c.json(sent)
+	}
+	for _, msg := range sent {
+		fmt.Fprintln(c.stdout, msg.ID)
+	}
+	return nil
+}
+
+// sendSMTP delivers the message to the server given by --smtp, upgrading to TLS when the server offers STARTTLS.
+// Without --from and --to, the envelope is taken from a message file's From, To, Cc and Bcc headers.
+func (c *cli) sendSMTP(ctx context.Context, raw []byte) error {
+	m := &c.message
+	from, to := m.from, m.to
+	if raw == nil {
+		var err error
+		if raw, err = m.build(); err != nil {
+			return err
+		}
+	} else {
+		parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		if from == "" {
+			addr, err := mail.ParseAddress(parsed.Header.Get("From"))
+			if err != nil {
+				return fmt.Errorf("no sender: give --from or a From header: %s", err)
+			}
+			from = addr.Address
+		}
+		if len(to) == 0 {
+			for _, field := range []string{"To", "Cc", "Bcc"} {
+				addrs, _ := parsed.Header.AddressList(field)
+				for _, addr := range addrs {
+					to = append(to, addr.Address)
+				}
+			}
+		}
+		raw = stripBcc(raw)
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("--to is required")
+	}
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+
+	host, _, err := net.SplitHostPort(c.smtp)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.smtp)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	session, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if ok, _ := session.Extension("STARTTLS"); ok {
+		if err := session.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: c.insecure}); err != nil {
+			return err
+		}
+	}
+	if c.smtpUser != "" {
+		if err := session.Auth(smtp.PlainAuth("", c.smtpUser, c.smtpPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := session.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := session.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("%s: %s", rcpt, err)
+		}
+	}
+	w, err := session.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return session.Quit()
+}
+
+func decodeHeader(v string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// stripBcc removes Bcc fields from a message's header so that recipients don't see each other.
+func stripBcc(raw []byte) []byte {
+	end := bytes.Index(raw, []byte("\r\n\r\n")) + 2
+	if end < 2 {
+		if end = bytes.Index(raw, []byte("\n\n")) + 1; end < 1 {
+			return raw
+		}
+	}
+
+	var out bytes.Buffer
+	skipping := false
+	for _, line := range bytes.SplitAfter(raw[:end], []byte("\n")) {
+		continued := len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		if !continued {
+			skipping = len(line) >= 4 && strings.EqualFold(string(line[:4]), "bcc:")
+		}
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	out.Write(raw[end:])
+	return out.Bytes()
+}
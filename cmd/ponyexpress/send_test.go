@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/ponyexpresstest"
+)
+
+func TestSendAPI(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	a, b := px.Mailbox(t), px.Mailbox(t)
+
+	code, out, errOut := run(px, "send", "--to", a, "--to", b, "--from", "brett@buddin.us", "--subject", "Howdy", "--text", "Some text")
+	Equal(t, code, 0)
+	Equal(t, errOut, "")
+	ids := strings.Fields(out)
+	Equal(t, len(ids), 2)
+
+	msg := px.RequireMessage(t, b, 5*time.Second, ponyexpresstest.SubjectContains("Howdy"))
+	Equal(t, msg.ID, ids[1])
+	Equal(t, msg.Sender, "brett@buddin.us")
+	Equal(t, msg.Body, "Some text")
+
+	code, _, errOut = run(px, "send", "--to", a, "--subject", "Howdy", "--text", "Some text", "--attach", "send.go")
+	Equal(t, code, 1)
+	MatchRegex(t, errOut, "can only be sent with --smtp")
+}
+
+func TestSendEMLToAPI(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	address := px.Mailbox(t)
+
+	eml := filepath.Join(t.TempDir(), "message.eml")
+	raw := "From: Brett <brett@buddin.us>\r\nSubject: =?utf-8?q?Caf=C3=A9?=\r\n\r\nSome text\r\n"
+	Equal(t, ioutil.WriteFile(eml, []byte(raw), 0644), nil)
+
+	code, _, errOut := run(px, "send", "--to", address, "--eml", eml)
+	Equal(t, code, 0)
+	Equal(t, errOut, "")
+
+	msg := px.RequireMessage(t, address, 5*time.Second, nil)
+	Equal(t, msg.Sender, "Brett <brett@buddin.us>")
+	Equal(t, msg.Subject, "Café")
+	Equal(t, msg.Body, "Some text\r\n")
+}
+
+func TestSendSMTP(t *testing.T) {
+	addr, received := smtpServer(t)
+
+	attachment := filepath.Join(t.TempDir(), "notes.txt")
+	Equal(t, ioutil.WriteFile(attachment, []byte("attached"), 0644), nil)
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"send", "--smtp", addr, "--from", "Brett <brett@buddin.us>", "--to", "a@example.com",
+		"--subject", "Howdy", "--text", "Some text", "--html", "<p>Some text</p>", "--attach", attachment,
+		"--header", "X-Test: yes"}, &stdout, &stderr)
+	Equal(t, stderr.String(), "")
+	Equal(t, code, 0)
+
+	envelope := <-received
+	Equal(t, envelope.from, "brett@buddin.us")
+	Equal(t, envelope.to, []string{"a@example.com"})
+
+	msg, err := mail.ReadMessage(strings.NewReader(envelope.data))
+	Equal(t, err, nil)
+	Equal(t, msg.Header.Get("Subject"), "Howdy")
+	Equal(t, msg.Header.Get("X-Test"), "yes")
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	Equal(t, err, nil)
+	Equal(t, mediaType, "multipart/mixed")
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	p, err := mr.NextPart()
+	Equal(t, err, nil)
+	MatchRegex(t, p.Header.Get("Content-Type"), "^multipart/alternative")
+
+	p, err = mr.NextPart()
+	Equal(t, err, nil)
+	Equal(t, p.FileName(), "notes.txt")
+	Equal(t, p.Header.Get("Content-Transfer-Encoding"), "base64")
+	content, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+	Equal(t, string(content), "attached")
+}
+
+func TestSendSMTPWithEML(t *testing.T) {
+	addr, received := smtpServer(t)
+
+	raw := "From: brett@buddin.us\r\nTo: a@example.com\r\nBcc: b@example.com,\r\n c@example.com\r\nSubject: Howdy\r\n\r\nSome text\r\n"
+	r, w, err := os.Pipe()
+	Equal(t, err, nil)
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	w.WriteString(raw)
+	w.Close()
+
+	var stdout, stderr bytes.Buffer
+	Equal(t, runCommand([]string{"send", "--smtp", addr, "--eml", "-"}, &stdout, &stderr), 0)
+
+	envelope := <-received
+	Equal(t, envelope.from, "brett@buddin.us")
+	Equal(t, envelope.to, []string{"a@example.com", "b@example.com", "c@example.com"})
+	Equal(t, envelope.data, "From: brett@buddin.us\r\nTo: a@example.com\r\nSubject: Howdy\r\n\r\nSome text\r\n")
+}
+
+type envelope struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer accepts a single SMTP session and reports what was delivered.
+func smtpServer(t *testing.T) (string, <-chan envelope) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Equal(t, err, nil)
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan envelope, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var e envelope
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				e.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+				reply("250 OK")
+			case "RCPT":
+				e.to = append(e.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var data bytes.Buffer
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				e.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				received <- e
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
@@ -0,0 +1,398 @@
+// Package config describes how ponyexpress is configured. Configuration is layered: defaults, then an optional JSON
+// file, then environment variables, then command-line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+// Config is the complete configuration of a ponyexpress instance.
+type Config struct {
+	HTTP      HTTP      `json:"http"`
+	Retention Retention `json:"retention"`
+	Limits    Limits    `json:"limits"`
+	Log       Log       `json:"log"`
+	Fixtures  Fixtures  `json:"fixtures"`
+	Testing   Testing   `json:"testing"`
+}
+
+// HTTP configures the HTTP API listener.
+type HTTP struct {
+	Addr            string    `json:"addr"`
+	ReadTimeout     Duration  `json:"read_timeout"`
+	WriteTimeout    Duration  `json:"write_timeout"`
+	ShutdownTimeout Duration  `json:"shutdown_timeout"`
+	TLS             TLS       `json:"tls"`
+	CORS            CORS      `json:"cors"`
+	RateLimit       RateLimit `json:"rate_limit"`
+}
+
+// RateLimit limits how many requests each caller may make: Burst at once and Rate per second after that. A Rate of
+// zero means no limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// CORS configures cross-origin requests. They're refused unless AllowedOrigins is set.
+type CORS struct {
+	AllowedOrigins   []string `json:"allowed_origins,omitempty"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAge           Duration `json:"max_age,omitempty"`
+}
+
+// Enabled reports whether cross-origin requests are allowed.
+func (c CORS) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// Options converts the configuration into options for server.CORS.
+func (c CORS) Options() server.CORSOptions {
+	return server.CORSOptions{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           time.Duration(c.MaxAge),
+	}
+}
+
+// Retention configures how long messages are kept.
+type Retention struct {
+	ExpireAfter Duration `json:"expire_after"`
+	SizeLimit   int      `json:"size_limit"`
+	EvictEvery  Duration `json:"evict_every"`
+	DirtyMax    int      `json:"dirty_max"`
+}
+
+// Limits configures the size of what is accepted.
+type Limits struct {
+	MaxMessageSize int `json:"max_message_size"`
+
+	// InboundRate limits how many messages per second each mailbox accepts, once InboundBurst messages have been
+	// received at once. Zero means no limit.
+	InboundRate  float64 `json:"inbound_rate"`
+	InboundBurst int     `json:"inbound_burst"`
+}
+
+// Fixtures configures the mailboxes and messages loaded at startup.
+type Fixtures struct {
+	// Path is a fixtures file or directory. See the fixtures package for their format.
+	Path string `json:"path,omitempty"`
+}
+
+// Testing configures test mode, for end-to-end environments that need to control time.
+type Testing struct {
+	// Enabled replaces the wall clock with one that can be frozen, set and advanced through the admin API. It only
+	// takes effect at startup.
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// Log configures logging.
+type Log struct {
+	Level      string            `json:"level"`
+	Format     string            `json:"format"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// Default returns the configuration used when nothing else is given.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:            ":3000",
+			ReadTimeout:     Duration(5 * time.Second),
+			WriteTimeout:    Duration(5 * time.Second),
+			ShutdownTimeout: Duration(10 * time.Second),
+			TLS: TLS{
+				ClientAuth: ClientAuthNone,
+			},
+			RateLimit: RateLimit{
+				Burst: 20,
+			},
+		},
+		Retention: Retention{
+			ExpireAfter: Duration(time.Hour),
+			SizeLimit:   500,
+			EvictEvery:  Duration(30 * time.Second),
+			DirtyMax:    100,
+		},
+		Limits: Limits{
+			MaxMessageSize: 10 << 20,
+			InboundBurst:   10,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "logfmt",
+		},
+	}
+}
+
+// LoadFile reads a JSON configuration file over the top of c. Settings missing from the file are left alone.
+func (c *Config) LoadFile(path string) error {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	default:
+		return fmt.Errorf("unsupported config file format %q: only .json is supported", ext)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// Env lists the environment variables ApplyEnv reads and the settings they override.
+var Env = map[string]string{
+	"HTTP_ADDR":                "http.addr",
+	"EXPIRE_AFTER":             "retention.expire_after",
+	"SIZE_LIMIT":               "retention.size_limit",
+	"EVICT_EVERY":              "retention.evict_every",
+	"DIRTY_MAX":                "retention.dirty_max",
+	"MAX_MESSAGE_SIZE":         "limits.max_message_size",
+	"TLS_CERT_FILE":            "http.tls.cert_file",
+	"TLS_KEY_FILE":             "http.tls.key_file",
+	"TLS_SELF_SIGNED":          "http.tls.self_signed",
+	"TLS_CLIENT_CA_FILE":       "http.tls.client_ca_file",
+	"TLS_CLIENT_AUTH":          "http.tls.client_auth",
+	"CORS_ALLOWED_ORIGINS":     "http.cors.allowed_origins",
+	"CORS_ALLOWED_METHODS":     "http.cors.allowed_methods",
+	"CORS_ALLOWED_HEADERS":     "http.cors.allowed_headers",
+	"CORS_EXPOSED_HEADERS":     "http.cors.exposed_headers",
+	"CORS_ALLOW_CREDENTIALS":   "http.cors.allow_credentials",
+	"CORS_MAX_AGE":             "http.cors.max_age",
+	"RATE_LIMIT":               "http.rate_limit.rate",
+	"RATE_LIMIT_BURST":         "http.rate_limit.burst",
+	"INBOUND_RATE_LIMIT":       "limits.inbound_rate",
+	"INBOUND_RATE_LIMIT_BURST": "limits.inbound_burst",
+	"LOG_LEVEL":                "log.level",
+	"LOG_FORMAT":               "log.format",
+	"LOG_LEVELS":               "log.subsystems",
+	"FIXTURES":                 "fixtures.path",
+	"TEST_MODE":                "testing.enabled",
+}
+
+// ApplyEnv overrides settings with those given in the environment. DEBUG=true is honored as LOG_LEVEL=debug.
+func (c *Config) ApplyEnv() error {
+	if debug, _ := strconv.ParseBool(os.Getenv("DEBUG")); debug {
+		c.Log.Level = "debug"
+	}
+	for name, key := range Env {
+		if v := os.Getenv(name); v != "" {
+			if err := c.Set(key, v); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Set changes a single setting, named by its dotted path (e.g. "retention.expire_after"), from its string form.
+func (c *Config) Set(key, value string) error {
+	var err error
+	switch key {
+	case "http.addr":
+		c.HTTP.Addr = value
+	case "http.read_timeout":
+		err = c.HTTP.ReadTimeout.Set(value)
+	case "http.write_timeout":
+		err = c.HTTP.WriteTimeout.Set(value)
+	case "http.shutdown_timeout":
+		err = c.HTTP.ShutdownTimeout.Set(value)
+	case "http.tls.cert_file":
+		c.HTTP.TLS.CertFile = value
+	case "http.tls.key_file":
+		c.HTTP.TLS.KeyFile = value
+	case "http.tls.self_signed":
+		c.HTTP.TLS.SelfSigned, err = strconv.ParseBool(value)
+	case "http.tls.client_ca_file":
+		c.HTTP.TLS.ClientCAFile = value
+	case "http.tls.client_auth":
+		c.HTTP.TLS.ClientAuth = value
+	case "http.cors.allowed_origins":
+		c.HTTP.CORS.AllowedOrigins = splitList(value)
+	case "http.cors.allowed_methods":
+		c.HTTP.CORS.AllowedMethods = splitList(value)
+	case "http.cors.allowed_headers":
+		c.HTTP.CORS.AllowedHeaders = splitList(value)
+	case "http.cors.exposed_headers":
+		c.HTTP.CORS.ExposedHeaders = splitList(value)
+	case "http.cors.allow_credentials":
+		c.HTTP.CORS.AllowCredentials, err = strconv.ParseBool(value)
+	case "http.cors.max_age":
+		err = c.HTTP.CORS.MaxAge.Set(value)
+	case "http.rate_limit.rate":
+		c.HTTP.RateLimit.Rate, err = strconv.ParseFloat(value, 64)
+	case "http.rate_limit.burst":
+		c.HTTP.RateLimit.Burst, err = strconv.Atoi(value)
+	case "retention.expire_after":
+		err = c.Retention.ExpireAfter.Set(value)
+	case "retention.size_limit":
+		c.Retention.SizeLimit, err = strconv.Atoi(value)
+	case "retention.evict_every":
+		err = c.Retention.EvictEvery.Set(value)
+	case "retention.dirty_max":
+		c.Retention.DirtyMax, err = strconv.Atoi(value)
+	case "limits.max_message_size":
+		c.Limits.MaxMessageSize, err = strconv.Atoi(value)
+	case "limits.inbound_rate":
+		c.Limits.InboundRate, err = strconv.ParseFloat(value, 64)
+	case "limits.inbound_burst":
+		c.Limits.InboundBurst, err = strconv.Atoi(value)
+	case "fixtures.path":
+		c.Fixtures.Path = value
+	case "testing.enabled":
+		c.Testing.Enabled, err = strconv.ParseBool(value)
+	case "log.level":
+		c.Log.Level = value
+	case "log.format":
+		c.Log.Format = value
+	case "log.subsystems":
+		var levels map[string]logger.Level
+		levels, err = logger.ParseSubsystemLevels(value)
+		if err == nil {
+			c.Log.Subsystems = map[string]string{}
+			for subsystem, l := range levels {
+				c.Log.Subsystems[subsystem] = l.String()
+			}
+		}
+	default:
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", key, err)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Validate checks every setting and reports all of the problems found.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	problems = append(problems, c.HTTP.TLS.problems()...)
+	check(c.HTTP.CORS.MaxAge >= 0, "http.cors.max_age must not be negative")
+	check(c.HTTP.RateLimit.Rate >= 0, "http.rate_limit.rate must not be negative")
+	check(c.HTTP.RateLimit.Rate == 0 || c.HTTP.RateLimit.Burst > 0, "http.rate_limit.burst must be positive")
+	check(c.Retention.ExpireAfter > 0, "retention.expire_after must be positive")
+	check(c.Retention.SizeLimit > 0, "retention.size_limit must be positive")
+	check(c.Retention.EvictEvery > 0, "retention.evict_every must be positive")
+	check(c.Retention.DirtyMax > 0, "retention.dirty_max must be positive")
+	check(c.Limits.MaxMessageSize > 0, "limits.max_message_size must be positive")
+	check(c.Limits.InboundRate >= 0, "limits.inbound_rate must not be negative")
+	check(c.Limits.InboundRate == 0 || c.Limits.InboundBurst > 0, "limits.inbound_burst must be positive")
+
+	_, err := logger.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: %v", err)
+	_, err = logger.ParseFormat(c.Log.Format)
+	check(err == nil, "log.format: %v", err)
+	for subsystem, level := range c.Log.Subsystems {
+		_, err := logger.ParseLevel(level)
+		check(err == nil, "log.subsystems.%s: %v", subsystem, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// MailboxSettings returns the retention and limit settings in the form the mailbox package takes them.
+func (c Config) MailboxSettings() mailbox.Settings {
+	return mailbox.Settings{
+		SizeLimit:      c.Retention.SizeLimit,
+		ExpireAfter:    time.Duration(c.Retention.ExpireAfter),
+		MaxMessageSize: c.Limits.MaxMessageSize,
+		EvictEvery:     time.Duration(c.Retention.EvictEvery),
+		DirtyMax:       c.Retention.DirtyMax,
+		InboundRate:    c.Limits.InboundRate,
+		InboundBurst:   c.Limits.InboundBurst,
+	}
+}
+
+// Apply puts the retention, limit and logging settings into effect. The configuration must be valid.
+func (c Config) Apply() {
+	mailbox.Configure(c.MailboxSettings())
+
+	level, _ := logger.ParseLevel(c.Log.Level)
+	format, _ := logger.ParseFormat(c.Log.Format)
+	subsystems := map[string]logger.Level{}
+	for subsystem, name := range c.Log.Subsystems {
+		subsystems[subsystem], _ = logger.ParseLevel(name)
+	}
+	logger.Configure(logger.Config{
+		Output:     os.Stderr,
+		Format:     format,
+		Level:      level,
+		Subsystems: subsystems,
+	})
+}
+
+// Duration is a time.Duration written in configuration as a string such as "90s" or "1h".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses a duration. It makes *Duration a flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"30s\" or \"1h\"")
+	}
+	return d.Set(s)
+}
@@ -0,0 +1,156 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "ponyexpress-config")
+	Equal(t, err, nil)
+	path := filepath.Join(dir, name)
+	Equal(t, ioutil.WriteFile(path, []byte(content), 0600), nil)
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	Equal(t, config.Default().Validate(), nil)
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{
+		"http": {"addr": ":4000"},
+		"retention": {"expire_after": "10m"},
+		"log": {"subsystems": {"gc": "debug"}}
+	}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg := config.Default()
+	Equal(t, cfg.LoadFile(path), nil)
+	Equal(t, cfg.HTTP.Addr, ":4000")
+	Equal(t, time.Duration(cfg.Retention.ExpireAfter), 10*time.Minute)
+	Equal(t, cfg.Retention.SizeLimit, 500)
+	Equal(t, cfg.Log.Subsystems["gc"], "debug")
+	Equal(t, cfg.Validate(), nil)
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	path := writeFile(t, "config.json", `{"retention": {"expire_afterr": "10m"}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg := config.Default()
+	NotEqual(t, cfg.LoadFile(path), nil)
+
+	path = writeFile(t, "config.yaml", `http: {}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	NotEqual(t, cfg.LoadFile(path), nil)
+}
+
+func TestApplyEnv(t *testing.T) {
+	os.Setenv("EXPIRE_AFTER", "2h")
+	os.Setenv("LOG_LEVELS", "http=debug")
+	defer os.Unsetenv("EXPIRE_AFTER")
+	defer os.Unsetenv("LOG_LEVELS")
+
+	cfg := config.Default()
+	Equal(t, cfg.ApplyEnv(), nil)
+	Equal(t, time.Duration(cfg.Retention.ExpireAfter), 2*time.Hour)
+	Equal(t, cfg.Log.Subsystems["http"], "debug")
+
+	os.Setenv("EXPIRE_AFTER", "soon")
+	NotEqual(t, cfg.ApplyEnv(), nil)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	cfg.Retention.SizeLimit = 0
+	cfg.Log.Level = "loud"
+
+	err := cfg.Validate()
+	NotEqual(t, err, nil)
+	Equal(t, strings.Contains(err.Error(), "retention.size_limit must be positive"), true)
+	Equal(t, strings.Contains(err.Error(), "unknown log level: loud"), true)
+}
+
+func TestSetUnknown(t *testing.T) {
+	cfg := config.Default()
+	NotEqual(t, cfg.Set("retention.forever", "true"), nil)
+}
+
+func TestValidateTLS(t *testing.T) {
+	cfg := config.Default()
+	cfg.HTTP.TLS.CertFile = "cert.pem"
+	cfg.HTTP.TLS.SelfSigned = true
+	cfg.HTTP.TLS.ClientAuth = "sometimes"
+	err := cfg.Validate()
+	NotEqual(t, err, nil)
+	Equal(t, strings.Contains(err.Error(), "http.tls.cert_file and http.tls.key_file must be given together"), true)
+	Equal(t, strings.Contains(err.Error(), "http.tls.self_signed can't be combined"), true)
+	Equal(t, strings.Contains(err.Error(), "http.tls.client_auth must be one of"), true)
+
+	cfg = config.Default()
+	Equal(t, cfg.Set("http.tls.client_auth", "require"), nil)
+	err = cfg.Validate()
+	NotEqual(t, err, nil)
+	Equal(t, strings.Contains(err.Error(), "requires http.tls.client_ca_file"), true)
+}
+
+func TestTLSServerConfig(t *testing.T) {
+	cfg := config.Default()
+	Equal(t, cfg.HTTP.TLS.Enabled(), false)
+	Equal(t, cfg.Set("http.tls.self_signed", "true"), nil)
+	Equal(t, cfg.Validate(), nil)
+	Equal(t, cfg.HTTP.TLS.Enabled(), true)
+
+	tlsConfig, err := cfg.HTTP.TLS.ServerConfig("127.0.0.1:3000")
+	Equal(t, err, nil)
+	Equal(t, len(tlsConfig.Certificates), 1)
+	Equal(t, tlsConfig.Certificates[0].Leaf.VerifyHostname("127.0.0.1"), nil)
+	Equal(t, tlsConfig.ClientCAs == nil, true)
+
+	cfg.HTTP.TLS.ClientCAFile = "/nonexistent/ca.pem"
+	cfg.HTTP.TLS.ClientAuth = config.ClientAuthRequire
+	_, err = cfg.HTTP.TLS.ServerConfig(":3000")
+	NotEqual(t, err, nil)
+}
+
+func TestCORS(t *testing.T) {
+	cfg := config.Default()
+	Equal(t, cfg.HTTP.CORS.Enabled(), false)
+	Equal(t, cfg.Set("http.cors.allowed_origins", "http://a.test, http://b.test"), nil)
+	Equal(t, cfg.Set("http.cors.max_age", "1m"), nil)
+	Equal(t, cfg.Validate(), nil)
+	Equal(t, cfg.HTTP.CORS.Enabled(), true)
+
+	opts := cfg.HTTP.CORS.Options()
+	Equal(t, opts.AllowedOrigins, []string{"http://a.test", "http://b.test"})
+	Equal(t, opts.MaxAge, time.Minute)
+}
+
+func TestRateLimits(t *testing.T) {
+	cfg := config.Default()
+	Equal(t, cfg.Set("http.rate_limit.rate", "2.5"), nil)
+	Equal(t, cfg.Set("limits.inbound_rate", "1"), nil)
+	Equal(t, cfg.Set("limits.inbound_burst", "0"), nil)
+	err := cfg.Validate()
+	NotEqual(t, err, nil)
+	Equal(t, strings.Contains(err.Error(), "limits.inbound_burst must be positive"), true)
+
+	Equal(t, cfg.Set("limits.inbound_burst", "5"), nil)
+	Equal(t, cfg.Validate(), nil)
+	Equal(t, cfg.HTTP.RateLimit.Rate, 2.5)
+
+	defer mailbox.Configure(mailbox.DefaultSettings())
+	cfg.Apply()
+	Equal(t, mailbox.CurrentSettings().InboundRate, 1.0)
+	Equal(t, mailbox.CurrentSettings().InboundBurst, 5)
+}
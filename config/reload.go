@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brettbuddin/ponyexpress/logger"
+)
+
+var log = logger.New("config")
+
+// Settings flattens the configuration into its settings, keyed by the names Set accepts.
+func (c Config) Settings() map[string]string {
+	subsystems := make([]string, 0, len(c.Log.Subsystems))
+	for subsystem, level := range c.Log.Subsystems {
+		subsystems = append(subsystems, subsystem+"="+level)
+	}
+	sort.Strings(subsystems)
+
+	return map[string]string{
+		"http.addr":                   c.HTTP.Addr,
+		"http.read_timeout":           c.HTTP.ReadTimeout.String(),
+		"http.write_timeout":          c.HTTP.WriteTimeout.String(),
+		"http.shutdown_timeout":       c.HTTP.ShutdownTimeout.String(),
+		"http.tls.cert_file":          c.HTTP.TLS.CertFile,
+		"http.tls.key_file":           c.HTTP.TLS.KeyFile,
+		"http.tls.self_signed":        fmt.Sprint(c.HTTP.TLS.SelfSigned),
+		"http.tls.client_ca_file":     c.HTTP.TLS.ClientCAFile,
+		"http.tls.client_auth":        c.HTTP.TLS.ClientAuth,
+		"http.cors.allowed_origins":   strings.Join(c.HTTP.CORS.AllowedOrigins, ","),
+		"http.cors.allowed_methods":   strings.Join(c.HTTP.CORS.AllowedMethods, ","),
+		"http.cors.allowed_headers":   strings.Join(c.HTTP.CORS.AllowedHeaders, ","),
+		"http.cors.exposed_headers":   strings.Join(c.HTTP.CORS.ExposedHeaders, ","),
+		"http.cors.allow_credentials": fmt.Sprint(c.HTTP.CORS.AllowCredentials),
+		"http.cors.max_age":           c.HTTP.CORS.MaxAge.String(),
+		"http.rate_limit.rate":        fmt.Sprint(c.HTTP.RateLimit.Rate),
+		"http.rate_limit.burst":       fmt.Sprint(c.HTTP.RateLimit.Burst),
+		"retention.expire_after":      c.Retention.ExpireAfter.String(),
+		"retention.size_limit":        fmt.Sprint(c.Retention.SizeLimit),
+		"retention.evict_every":       c.Retention.EvictEvery.String(),
+		"retention.dirty_max":         fmt.Sprint(c.Retention.DirtyMax),
+		"limits.max_message_size":     fmt.Sprint(c.Limits.MaxMessageSize),
+		"limits.inbound_rate":         fmt.Sprint(c.Limits.InboundRate),
+		"limits.inbound_burst":        fmt.Sprint(c.Limits.InboundBurst),
+		"log.level":                   c.Log.Level,
+		"log.format":                  c.Log.Format,
+		"log.subsystems":              strings.Join(subsystems, ","),
+		"fixtures.path":               c.Fixtures.Path,
+		"testing.enabled":             fmt.Sprint(c.Testing.Enabled),
+	}
+}
+
+// Change is a setting that differs between two configurations.
+type Change struct {
+	Setting string `json:"setting"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+
+	// Restart is set for settings that only take effect when ponyexpress is restarted.
+	Restart bool `json:"restart,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s: %q -> %q", c.Setting, c.From, c.To)
+	if c.Restart {
+		s += " (requires restart)"
+	}
+	return s
+}
+
+// Diff lists the settings that differ between two configurations, ordered by setting.
+func Diff(from, to Config) []Change {
+	a, b := from.Settings(), to.Settings()
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changes := []Change{}
+	for _, k := range keys {
+		if a[k] != b[k] {
+			changes = append(changes, Change{
+				Setting: k,
+				From:    a[k],
+				To:      b[k],
+				Restart: restartOnly(k),
+			})
+		}
+	}
+	return changes
+}
+
+// restartOnly reports whether a setting keeps its old value until ponyexpress is restarted. Of the listener settings,
+// only the request rate limits can change while running.
+func restartOnly(setting string) bool {
+	if strings.HasPrefix(setting, "http.rate_limit.") {
+		return false
+	}
+	return strings.HasPrefix(setting, "http.") || strings.HasPrefix(setting, "testing.")
+}
+
+// Reloader re-reads the configuration and applies the settings that can change while running.
+type Reloader struct {
+	sync.Mutex
+	current  Config
+	load     func() (Config, error)
+	onReload []func(Config)
+}
+
+// NewReloader creates a Reloader for a configuration that is already applied. Load produces the new configuration
+// each time Reload is called.
+func NewReloader(current Config, load func() (Config, error)) *Reloader {
+	return &Reloader{current: current, load: load}
+}
+
+// OnReload registers f to apply settings that live outside the packages Apply configures. It's called with each
+// configuration put into effect by Reload.
+func (r *Reloader) OnReload(f func(Config)) {
+	r.Lock()
+	defer r.Unlock()
+	r.onReload = append(r.onReload, f)
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() Config {
+	r.Lock()
+	defer r.Unlock()
+	return r.current
+}
+
+// Reload loads, validates and applies the configuration, logging and returning what changed. When the new
+// configuration is invalid nothing is applied. Listener settings other than the rate limits, and test mode, are
+// reported but keep their old values until ponyexpress is restarted.
+func (r *Reloader) Reload() ([]Change, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	next, err := r.load()
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		log.Error("reload failed", logger.Fields{"error": err})
+		return nil, err
+	}
+
+	changes := Diff(r.current, next)
+	rateLimit := next.HTTP.RateLimit
+	next.HTTP = r.current.HTTP
+	next.HTTP.RateLimit = rateLimit
+	next.Testing = r.current.Testing
+	next.Apply()
+	for _, f := range r.onReload {
+		f(next)
+	}
+	r.current = next
+
+	log.Info("reloaded", logger.Fields{"changes": len(changes)})
+	for _, c := range changes {
+		log.Info("changed", logger.Fields{
+			"setting": c.Setting,
+			"from":    c.From,
+			"to":      c.To,
+			"restart": c.Restart,
+		})
+	}
+	return changes, nil
+}
@@ -0,0 +1,71 @@
+package config_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+func TestDiff(t *testing.T) {
+	a := config.Default()
+	b := config.Default()
+	b.HTTP.Addr = ":4000"
+	b.Retention.SizeLimit = 10
+	b.Log.Subsystems = map[string]string{"http": "debug", "gc": "error"}
+
+	changes := config.Diff(a, b)
+	Equal(t, len(changes), 3)
+	Equal(t, changes[0], config.Change{Setting: "http.addr", From: ":3000", To: ":4000", Restart: true})
+	Equal(t, changes[1], config.Change{Setting: "log.subsystems", From: "", To: "gc=error,http=debug"})
+	Equal(t, changes[2], config.Change{Setting: "retention.size_limit", From: "500", To: "10"})
+
+	Equal(t, len(config.Diff(a, a)), 0)
+}
+
+func TestReload(t *testing.T) {
+	defer mailbox.Configure(mailbox.DefaultSettings())
+
+	current := config.Default()
+	next := config.Default()
+	next.HTTP.Addr = ":4000"
+	next.Testing.Enabled = true
+	next.HTTP.RateLimit.Rate = 2
+	next.Retention.ExpireAfter = config.Duration(time.Minute)
+	var loadErr error
+
+	reloader := config.NewReloader(current, func() (config.Config, error) {
+		return next, loadErr
+	})
+	var reloaded []config.Config
+	reloader.OnReload(func(cfg config.Config) { reloaded = append(reloaded, cfg) })
+
+	changes, err := reloader.Reload()
+	Equal(t, err, nil)
+	Equal(t, len(changes), 4)
+	Equal(t, changes[1], config.Change{Setting: "http.rate_limit.rate", From: "0", To: "2"})
+	Equal(t, changes[3], config.Change{Setting: "testing.enabled", From: "false", To: "true", Restart: true})
+	Equal(t, mailbox.CurrentSettings().ExpireAfter, time.Minute)
+	Equal(t, len(reloaded), 1)
+	Equal(t, reloaded[0].HTTP.RateLimit.Rate, 2.0)
+
+	// Listener and test mode settings wait for a restart, but rate limits don't
+	Equal(t, reloader.Current().HTTP.Addr, ":3000")
+	Equal(t, reloader.Current().HTTP.RateLimit.Rate, 2.0)
+	Equal(t, reloader.Current().Testing.Enabled, false)
+
+	// Invalid configurations are not applied
+	next.Retention.ExpireAfter = 0
+	_, err = reloader.Reload()
+	NotEqual(t, err, nil)
+	Equal(t, mailbox.CurrentSettings().ExpireAfter, time.Minute)
+	Equal(t, len(reloaded), 1)
+
+	loadErr = fmt.Errorf("unreadable")
+	_, err = reloader.Reload()
+	Equal(t, err, loadErr)
+}
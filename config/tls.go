@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+// Client certificate policies.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:    tls.NoClientCert,
+	ClientAuthRequest: tls.VerifyClientCertIfGiven,
+	ClientAuthRequire: tls.RequireAndVerifyClientCert,
+}
+
+// TLS configures HTTPS. The API is served over HTTPS, and HTTP/2, when a certificate is configured or SelfSigned is
+// set.
+type TLS struct {
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	SelfSigned bool   `json:"self_signed,omitempty"`
+
+	// ClientCAFile holds the CAs trusted to sign client certificates. Callers presenting a certificate it verifies are
+	// identified by the certificate's common name.
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+
+	// ClientAuth is "none", "request" (verify client certificates when they are given) or "require".
+	ClientAuth string `json:"client_auth"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.SelfSigned
+}
+
+func (t TLS) problems() []string {
+	var problems []string
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		problems = append(problems, "http.tls.cert_file and http.tls.key_file must be given together")
+	}
+	if t.SelfSigned && t.CertFile != "" {
+		problems = append(problems, "http.tls.self_signed can't be combined with http.tls.cert_file")
+	}
+	if _, ok := clientAuthTypes[t.ClientAuth]; !ok {
+		problems = append(problems, fmt.Sprintf("http.tls.client_auth must be one of none, request or require: %q", t.ClientAuth))
+	}
+	if t.ClientAuth != ClientAuthNone && t.ClientCAFile == "" {
+		problems = append(problems, "http.tls.client_auth requires http.tls.client_ca_file")
+	}
+	if t.ClientCAFile != "" && !t.Enabled() {
+		problems = append(problems, "http.tls.client_ca_file requires http.tls.cert_file or http.tls.self_signed")
+	}
+	return problems
+}
+
+// ServerConfig loads the certificates and builds the tls.Config for a server listening at addr. Self-signed
+// certificates are issued for localhost and the host in addr. The configuration must be valid.
+func (t TLS) ServerConfig(addr string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if t.SelfSigned {
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if host, _, _ := net.SplitHostPort(addr); host != "" {
+			hosts = append(hosts, host)
+		}
+		cert, err = server.SelfSignedCertificate(hosts...)
+	} else {
+		cert, err = tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuth],
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", t.ClientCAFile)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,315 @@
+// Package fixtures seeds a Registry with known mailboxes and messages, for demo and end-to-end environments that need
+// the same mail every time ponyexpress starts.
+//
+// Fixtures are read from a JSON file or a directory. A file lists mailboxes and their messages:
+//
+//	{"mailboxes": [{"id": "demo", "pinned": true, "messages": [
+//	    {"sender": "brett@buddin.us", "subject": "Welcome", "body": "Hi", "received": "-10m"},
+//	    {"eml": "invoice.eml", "received": "-1h", "seen": true}
+//	]}]}
+//
+// A directory may hold any number of such files, plus a subdirectory per mailbox holding messages as .eml files or
+// .json files of a single message each, loaded in file name order. Times are either RFC 3339 or relative to when the
+// fixtures are loaded, such as "-10m"; .eml files may give one in an X-Ponyexpress-Received field.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/brettbuddin/ponyexpress/archive"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// File is the contents of a fixtures file.
+type File struct {
+	Mailboxes []Mailbox `json:"mailboxes"`
+}
+
+// Mailbox describes a mailbox and the messages it starts with. Messages in a pinned mailbox are pinned unless they say
+// otherwise.
+type Mailbox struct {
+	ID             string    `json:"id"`
+	MaxMessageSize int       `json:"max_message_size,omitempty"`
+	Pinned         bool      `json:"pinned,omitempty"`
+	Messages       []Message `json:"messages,omitempty"`
+
+	// dir is the directory that relative .eml paths are found in.
+	dir string
+}
+
+// Message describes a message. EML names a file, relative to the fixtures file, to read the message from; the other
+// fields override what's in it.
+type Message struct {
+	ID       string              `json:"id,omitempty"`
+	EML      string              `json:"eml,omitempty"`
+	Sender   string              `json:"sender,omitempty"`
+	Subject  string              `json:"subject,omitempty"`
+	Body     string              `json:"body,omitempty"`
+	Received string              `json:"received,omitempty"`
+	Seen     bool                `json:"seen,omitempty"`
+	Flagged  bool                `json:"flagged,omitempty"`
+	Pinned   *bool               `json:"pinned,omitempty"`
+	Tags     []string            `json:"tags,omitempty"`
+	Headers  map[string][]string `json:"headers,omitempty"`
+}
+
+// Fixture is a mailbox ready to be installed, with message times resolved.
+type Fixture struct {
+	ID             string
+	MaxMessageSize int
+	Messages       []*mailbox.Message
+}
+
+// Load reads the fixtures at path, a file or a directory, resolving relative times against now. Every fixture is read
+// before any is returned, so a mistake anywhere is reported without anything having been installed.
+func Load(path string, now time.Time) ([]*Fixture, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxes []Mailbox
+	if info.IsDir() {
+		mailboxes, err = readDir(path)
+	} else {
+		mailboxes, err = readFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := make([]*Fixture, len(mailboxes))
+	for i, m := range mailboxes {
+		if fixtures[i], err = m.resolve(now); err != nil {
+			return nil, fmt.Errorf("mailbox %s: %s", m.ID, err)
+		}
+	}
+	return fixtures, nil
+}
+
+func readFile(path string) ([]Mailbox, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for i := range f.Mailboxes {
+		if f.Mailboxes[i].ID == "" {
+			return nil, fmt.Errorf("%s: mailbox without an id", path)
+		}
+		f.Mailboxes[i].dir = filepath.Dir(path)
+	}
+	return f.Mailboxes, nil
+}
+
+// readDir reads the fixtures files in a directory, then adds the messages in each subdirectory to the mailbox it's
+// named after.
+func readDir(dir string) ([]Mailbox, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxes []Mailbox
+	index := map[string]int{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		found, err := readFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range found {
+			if _, ok := index[m.ID]; ok {
+				return nil, fmt.Errorf("mailbox %s is defined more than once", m.ID)
+			}
+			index[m.ID] = len(mailboxes)
+			mailboxes = append(mailboxes, m)
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id := entry.Name()
+		messages, err := readMessageDir(filepath.Join(dir, id))
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[id]
+		if !ok {
+			i, index[id] = len(mailboxes), len(mailboxes)
+			mailboxes = append(mailboxes, Mailbox{ID: id})
+		}
+		mailboxes[i].Messages = append(mailboxes[i].Messages, messages...)
+	}
+	return mailboxes, nil
+}
+
+// readMessageDir reads the .eml and .json messages in a mailbox's directory, in file name order. Messages read from
+// .eml files are identified by their file name unless they say otherwise.
+func readMessageDir(dir string) ([]Message, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var messages []Message
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch ext := filepath.Ext(entry.Name()); {
+		case entry.IsDir():
+		case ext == ".eml":
+			messages = append(messages, Message{EML: path})
+		case ext == ".json":
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var m Message
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&m); err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			if m.EML != "" && !filepath.IsAbs(m.EML) {
+				m.EML = filepath.Join(dir, m.EML)
+			}
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (m Mailbox) resolve(now time.Time) (*Fixture, error) {
+	f := &Fixture{ID: m.ID, MaxMessageSize: m.MaxMessageSize}
+	for i, fm := range m.Messages {
+		msg, err := fm.resolve(m.dir, now)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %s", i+1, err)
+		}
+		msg.Pinned = m.Pinned
+		if fm.Pinned != nil {
+			msg.Pinned = *fm.Pinned
+		}
+		f.Messages = append(f.Messages, msg)
+	}
+	return f, nil
+}
+
+func (fm Message) resolve(dir string, now time.Time) (*mailbox.Message, error) {
+	msg := &mailbox.Message{}
+	received := fm.Received
+	if fm.EML != "" {
+		path := fm.EML
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if msg, err = archive.ParseMessage(raw); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if msg.ID == "" {
+			msg.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		if received == "" {
+			received = receivedField(raw)
+		}
+	}
+
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&msg.ID, fm.ID)
+	set(&msg.Sender, fm.Sender)
+	set(&msg.Subject, fm.Subject)
+	set(&msg.Body, fm.Body)
+	msg.Seen = msg.Seen || fm.Seen
+	msg.Flagged = msg.Flagged || fm.Flagged
+	if fm.Tags != nil {
+		msg.Tags = fm.Tags
+	}
+	for k, v := range fm.Headers {
+		if msg.Headers == nil {
+			msg.Headers = map[string][]string{}
+		}
+		msg.Headers[k] = v
+	}
+
+	if received != "" || msg.Received.IsZero() {
+		t, err := ParseTime(received, now)
+		if err != nil {
+			return nil, err
+		}
+		msg.Received = t
+	}
+	if msg.ID == "" {
+		msg.ID = uuid.NewV4().String()
+	}
+	if msg.Sender == "" {
+		return nil, fmt.Errorf("sender is required")
+	}
+	return msg, nil
+}
+
+// receivedField returns the X-Ponyexpress-Received field of a .eml file when it holds a relative time, which
+// archive.ParseMessage doesn't understand.
+func receivedField(raw []byte) string {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	v := parsed.Header.Get("X-Ponyexpress-Received")
+	if strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+") || v == "now" {
+		return v
+	}
+	return ""
+}
+
+// ParseTime reads a time given either in RFC 3339 format or relative to now, such as "-10m" or "+1h". An empty string
+// or "now" is now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	switch {
+	case s == "" || s == "now":
+		return now, nil
+	case strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+"):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time: %s", s)
+		}
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s (want RFC 3339 or relative, e.g. -10m)", s)
+	}
+	return t, nil
+}
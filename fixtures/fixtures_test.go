@@ -0,0 +1,145 @@
+package fixtures_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/fixtures"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+var now = time.Date(2016, 6, 30, 8, 17, 36, 0, time.UTC)
+
+func write(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "invoice.eml"), "From: billing@example.com\r\nSubject: Invoice\r\nX-Ponyexpress-Received: -1h\r\n\r\nPay up.\r\n")
+	write(t, filepath.Join(dir, "fixtures.json"), `{"mailboxes": [{"id": "demo", "pinned": true, "max_message_size": 1024, "messages": [
+		{"id": "1", "sender": "brett@buddin.us", "subject": "Welcome", "body": "Hi", "received": "-10m", "pinned": false},
+		{"eml": "invoice.eml", "seen": true, "tags": ["billing"]}
+	]}]}`)
+
+	loaded, err := fixtures.Load(filepath.Join(dir, "fixtures.json"), now)
+	Equal(t, err, nil)
+	Equal(t, len(loaded), 1)
+	Equal(t, loaded[0].ID, "demo")
+	Equal(t, loaded[0].MaxMessageSize, 1024)
+	Equal(t, len(loaded[0].Messages), 2)
+
+	welcome, invoice := loaded[0].Messages[0], loaded[0].Messages[1]
+	Equal(t, welcome.Subject, "Welcome")
+	Equal(t, welcome.Received, now.Add(-10*time.Minute))
+	Equal(t, welcome.Pinned, false)
+
+	Equal(t, invoice.ID, "invoice")
+	Equal(t, invoice.Sender, "billing@example.com")
+	Equal(t, invoice.Body, "Pay up.\r\n")
+	Equal(t, invoice.Received, now.Add(-time.Hour))
+	Equal(t, invoice.Seen, true)
+	Equal(t, invoice.Tags, []string{"billing"})
+	Equal(t, invoice.Pinned, true)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "mailboxes.json"), `{"mailboxes": [{"id": "demo", "pinned": true}]}`)
+	write(t, filepath.Join(dir, "demo", "2-reply.json"), `{"sender": "brett@buddin.us", "subject": "Re: Hello", "received": "2016-06-30T08:00:00Z"}`)
+	write(t, filepath.Join(dir, "demo", "1-hello.eml"), "From: brett@buddin.us\r\nSubject: Hello\r\n\r\nHi\r\n")
+	write(t, filepath.Join(dir, "other", "a.eml"), "From: brett@buddin.us\r\n\r\n")
+
+	loaded, err := fixtures.Load(dir, now)
+	Equal(t, err, nil)
+	Equal(t, len(loaded), 2)
+
+	Equal(t, loaded[0].ID, "demo")
+	Equal(t, len(loaded[0].Messages), 2)
+	Equal(t, loaded[0].Messages[0].ID, "1-hello")
+	Equal(t, loaded[0].Messages[0].Received, now)
+	Equal(t, loaded[0].Messages[0].Pinned, true)
+	Equal(t, loaded[0].Messages[1].Subject, "Re: Hello")
+	Equal(t, loaded[0].Messages[1].Received, time.Date(2016, 6, 30, 8, 0, 0, 0, time.UTC))
+
+	Equal(t, loaded[1].ID, "other")
+	Equal(t, loaded[1].Messages[0].Pinned, false)
+}
+
+func TestLoadErrors(t *testing.T) {
+	for content, err := range map[string]string{
+		`{"mailboxes": [{"messages": []}]}`:                                                    "mailbox without an id",
+		`{"mailboxes": [{"id": "a", "bogus": true}]}`:                                          `json: unknown field "bogus"`,
+		`{"mailboxes": [{"id": "a", "messages": [{"subject": "No sender"}]}]}`:                 "mailbox a: message 1: sender is required",
+		`{"mailboxes": [{"id": "a", "messages": [{"sender": "a", "received": "yesterday"}]}]}`: "mailbox a: message 1: invalid time: yesterday",
+		`{"mailboxes": [{"id": "a", "messages": [{"eml": "missing.eml"}]}]}`:                   "no such file or directory",
+	} {
+		path := filepath.Join(t.TempDir(), "fixtures.json")
+		write(t, path, content)
+		_, e := fixtures.Load(path, now)
+		MatchRegex(t, e.Error(), err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for s, want := range map[string]time.Time{
+		"":                     now,
+		"now":                  now,
+		"-10m":                 now.Add(-10 * time.Minute),
+		"+1h30m":               now.Add(90 * time.Minute),
+		"2016-06-30T08:00:00Z": time.Date(2016, 6, 30, 8, 0, 0, 0, time.UTC),
+	} {
+		got, err := fixtures.ParseTime(s, now)
+		Equal(t, err, nil)
+		Equal(t, got, want)
+	}
+
+	_, err := fixtures.ParseTime("-ten minutes", now)
+	Equal(t, err.Error(), "invalid relative time: -ten minutes")
+}
+
+func TestInstall(t *testing.T) {
+	registry := mailbox.NewRegistry()
+	defer registry.Close()
+
+	box, _ := registry.Create("demo")
+	box.Push(&mailbox.Message{ID: "stale", Sender: "brett@buddin.us", Received: time.Now()})
+	registry.Create("other")
+
+	fixture := func() []*fixtures.Fixture {
+		return []*fixtures.Fixture{
+			{ID: "demo", Messages: []*mailbox.Message{{ID: "1", Sender: "brett@buddin.us", Received: time.Now(), Pinned: true}}},
+			{ID: "new", Messages: []*mailbox.Message{{ID: "2", Sender: "brett@buddin.us", Received: time.Now()}}},
+		}
+	}
+	for i := 0; i < 2; i++ {
+		summary, err := fixtures.Install(registry, fixture())
+		Equal(t, err, nil)
+		Equal(t, summary, fixtures.Summary{Mailboxes: 2, Messages: 2})
+
+		messages := box.Messages()
+		Equal(t, len(messages), 1)
+		Equal(t, messages[0].ID, "1")
+	}
+
+	_, err := registry.Get("new")
+	Equal(t, err, nil)
+	_, err = registry.Get("other")
+	Equal(t, err, nil)
+
+	_, err = fixtures.Install(registry, []*fixtures.Fixture{
+		{ID: "demo", Messages: []*mailbox.Message{{ID: "1", Sender: "a"}, {ID: "1", Sender: "b"}}},
+	})
+	Equal(t, err.Error(), "mailbox demo: duplicate message: 1")
+	Equal(t, len(box.Messages()), 1)
+}
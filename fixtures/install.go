@@ -0,0 +1,85 @@
+package fixtures
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// ErrNotConfigured is returned by a Loader without a fixtures path.
+var ErrNotConfigured = fmt.Errorf("no fixtures configured")
+
+// Summary counts what was installed.
+type Summary struct {
+	Mailboxes int `json:"mailboxes"`
+	Messages  int `json:"messages"`
+}
+
+// Install puts fixtures into a Registry. Each fixture mailbox is created if it doesn't exist and emptied if it does,
+// then given the fixture's messages. Other mailboxes are left alone.
+func Install(r *mailbox.Registry, fixtures []*Fixture) (Summary, error) {
+	for _, f := range fixtures {
+		maxSize := f.MaxMessageSize
+		if maxSize == 0 {
+			maxSize = mailbox.CurrentSettings().MaxMessageSize
+		}
+		ids := map[string]bool{}
+		for _, m := range f.Messages {
+			if m.Size() > maxSize {
+				return Summary{}, fmt.Errorf("mailbox %s: message %s exceeds maximum size of %d bytes", f.ID, m.ID, maxSize)
+			}
+			if ids[m.ID] {
+				return Summary{}, fmt.Errorf("mailbox %s: duplicate message: %s", f.ID, m.ID)
+			}
+			ids[m.ID] = true
+		}
+	}
+
+	var summary Summary
+	for _, f := range fixtures {
+		box, err := r.Get(f.ID)
+		if err != nil {
+			if box, err = r.Create(f.ID); err != nil {
+				return summary, err
+			}
+		}
+		box.Purge()
+		box.SetMaxSize(f.MaxMessageSize)
+		if err := box.Import(f.Messages); err != nil {
+			return summary, fmt.Errorf("mailbox %s: %s", f.ID, err)
+		}
+		summary.Mailboxes++
+		summary.Messages += len(f.Messages)
+	}
+	return summary, nil
+}
+
+// Loader loads fixtures into a Registry from a path that may change while ponyexpress runs, e.g. when its
+// configuration is reloaded.
+type Loader struct {
+	sync.Mutex
+	registry *mailbox.Registry
+	path     func() string
+}
+
+// NewLoader creates a Loader reading fixtures from the path returned by path at each load.
+func NewLoader(registry *mailbox.Registry, path func() string) *Loader {
+	return &Loader{registry: registry, path: path}
+}
+
+// Load reads and installs the fixtures, resetting fixture mailboxes to their initial contents. Relative times are
+// resolved against the time of the load.
+func (l *Loader) Load() (Summary, error) {
+	l.Lock()
+	defer l.Unlock()
+	path := l.path()
+	if path == "" {
+		return Summary{}, ErrNotConfigured
+	}
+	fixtures, err := Load(path, l.registry.Clock().Now())
+	if err != nil {
+		return Summary{}, err
+	}
+	return Install(l.registry, fixtures)
+}
@@ -1,35 +1,380 @@
+// Package logger writes leveled, structured log lines in logfmt or JSON.
+//
+// Loggers belong to a subsystem (such as "http", "smtp" or "gc") whose level can be configured independently of the
+// others. Fields attached to a Logger are written with every line it logs, sorted by key.
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
-var debug, _ = strconv.ParseBool(os.Getenv("DEBUG"))
+// Level is the severity of a log line. The zero value is InfoLevel.
+type Level int
 
-func Infof(format string, args ...interface{}) {
-	log.Printf("INFO -- "+format, args...)
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
 }
 
-func Debugf(format string, args ...interface{}) {
-	if debug {
-		log.Printf("DEBUG -- "+format, args...)
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
 	}
+	return strconv.Itoa(int(l))
 }
 
-func Errorf(format string, args ...interface{}) {
-	log.Printf("ERROR -- "+format, args...)
+// ParseLevel parses a level name.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %s", s)
+}
+
+// Format is the encoding of log lines.
+type Format int
+
+const (
+	LogfmtFormat Format = iota
+	JSONFormat
+)
+
+// ParseFormat parses a format name: "logfmt" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "logfmt":
+		return LogfmtFormat, nil
+	case "json":
+		return JSONFormat, nil
+	}
+	return 0, fmt.Errorf("unknown log format: %s", s)
 }
 
+// Config controls where and how log lines are written.
+type Config struct {
+	Output io.Writer
+	Format Format
+
+	// Level is the minimum level logged by subsystems without a level of their own.
+	Level Level
+
+	// Subsystems overrides Level for individual subsystems.
+	Subsystems map[string]Level
+}
+
+// ParseSubsystemLevels parses levels given as a comma-separated list of subsystem=level pairs, e.g.
+// "http=debug,gc=error".
+func ParseSubsystemLevels(s string) (map[string]Level, error) {
+	levels := map[string]Level{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid subsystem level: %s", pair)
+		}
+		l, err := ParseLevel(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		levels[parts[0]] = l
+	}
+	return levels, nil
+}
+
+// ConfigFromEnv builds a Config from LOG_LEVEL, LOG_FORMAT and LOG_LEVELS (per-subsystem levels). DEBUG=true is
+// honored as LOG_LEVEL=debug.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Output: os.Stderr, Level: InfoLevel}
+	if debug, _ := strconv.ParseBool(os.Getenv("DEBUG")); debug {
+		cfg.Level = DebugLevel
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		l, err := ParseLevel(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Level = l
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		f, err := ParseFormat(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Format = f
+	}
+	if v := os.Getenv("LOG_LEVELS"); v != "" {
+		levels, err := ParseSubsystemLevels(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Subsystems = levels
+	}
+	return cfg, nil
+}
+
+var (
+	mu     sync.Mutex
+	config Config
+)
+
+func init() {
+	cfg, err := ConfigFromEnv()
+	Configure(cfg)
+	if err != nil {
+		Errorf("%s", err)
+	}
+}
+
+// Configure replaces the logging configuration. It applies to every Logger, including those already created.
+func Configure(cfg Config) {
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config = cfg
+}
+
+// Current returns the logging configuration in effect, for restoring it after a temporary change.
+func Current() Config {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg := config
+	cfg.Subsystems = make(map[string]Level, len(config.Subsystems))
+	for subsystem, l := range config.Subsystems {
+		cfg.Subsystems[subsystem] = l
+	}
+	return cfg
+}
+
+// Enabled reports whether a subsystem logs lines at a level.
+func Enabled(subsystem string, l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled(subsystem, l)
+}
+
+func enabled(subsystem string, l Level) bool {
+	min, ok := config.Subsystems[subsystem]
+	if !ok {
+		min = config.Level
+	}
+	return l >= min
+}
+
+// Fields are key/value pairs attached to a log line.
 type Fields map[string]interface{}
 
+// String renders the fields as logfmt, sorted by key.
 func (f Fields) String() string {
-	output := []string{}
-	for k, v := range f {
-		output = append(output, fmt.Sprintf("%s=%v", k, v))
+	var buf bytes.Buffer
+	for i, k := range f.keys() {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		writeLogfmtPair(&buf, k, f[k])
+	}
+	return buf.String()
+}
+
+func (f Fields) keys() []string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Logger writes log lines for a subsystem.
+type Logger struct {
+	subsystem string
+	fields    Fields
+}
+
+// New creates a Logger for a subsystem.
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// With returns a Logger that adds fields to every line.
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
 	}
-	return strings.Join(output, " ")
+	return &Logger{subsystem: l.subsystem, fields: merged}
+}
+
+// Subsystem returns a Logger for another subsystem carrying the same fields.
+func (l *Logger) Subsystem(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem, fields: l.fields}
+}
+
+type contextKey struct{}
+
+// NewContext returns a Context carrying a Logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns a Logger for a subsystem carrying the fields of the Logger in the Context, if there is one.
+func FromContext(ctx context.Context, subsystem string) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l.Subsystem(subsystem)
+	}
+	return New(subsystem)
+}
+
+func (l *Logger) Debug(msg string, fields Fields) { l.log(DebugLevel, msg, fields) }
+func (l *Logger) Info(msg string, fields Fields)  { l.log(InfoLevel, msg, fields) }
+func (l *Logger) Warn(msg string, fields Fields)  { l.log(WarnLevel, msg, fields) }
+func (l *Logger) Error(msg string, fields Fields) { l.log(ErrorLevel, msg, fields) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) log(level Level, msg string, fields Fields) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !enabled(l.subsystem, level) {
+		return
+	}
+
+	all := Fields{}
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+
+	var buf bytes.Buffer
+	header := []struct {
+		key   string
+		value interface{}
+	}{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level.String()},
+		{"subsystem", l.subsystem},
+		{"msg", msg},
+	}
+	switch config.Format {
+	case JSONFormat:
+		buf.WriteByte('{')
+		for i, h := range header {
+			if h.key == "subsystem" && l.subsystem == "" {
+				continue
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONPair(&buf, h.key, h.value)
+		}
+		for _, k := range all.keys() {
+			buf.WriteByte(',')
+			writeJSONPair(&buf, k, all[k])
+		}
+		buf.WriteByte('}')
+	default:
+		for i, h := range header {
+			if h.key == "subsystem" && l.subsystem == "" {
+				continue
+			}
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeLogfmtPair(&buf, h.key, h.value)
+		}
+		for _, k := range all.keys() {
+			buf.WriteByte(' ')
+			writeLogfmtPair(&buf, k, all[k])
+		}
+	}
+	buf.WriteByte('\n')
+	config.Output.Write(buf.Bytes())
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n\\") {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+func writeJSONPair(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(v)
+}
+
+// std is the Logger used by the package-level functions.
+var std = New("")
+
+func Debugf(format string, args ...interface{}) {
+	std.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	std.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	std.Warnf(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	std.Errorf(format, args...)
 }
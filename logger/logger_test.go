@@ -0,0 +1,79 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/logger"
+)
+
+func TestFieldsString(t *testing.T) {
+	f := logger.Fields{"c": 3, "a": "one two", "b": ""}
+	Equal(t, f.String(), `a="one two" b="" c=3`)
+}
+
+func TestLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	logger.Configure(logger.Config{Output: &buf, Level: logger.InfoLevel})
+	defer logger.Configure(logger.Config{})
+
+	log := logger.New("http").With(logger.Fields{"request_id": "abc"})
+	log.Debug("hidden", nil)
+	log.Info("finished", logger.Fields{"status": 200, "method": "GET"})
+
+	line := buf.String()
+	Equal(t, strings.Count(line, "\n"), 1)
+	MatchRegex(t, line, `^time=\S+ level=info subsystem=http msg=finished method=GET request_id=abc status=200\n$`)
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger.Configure(logger.Config{Output: &buf, Format: logger.JSONFormat})
+	defer logger.Configure(logger.Config{})
+
+	logger.New("gc").Error("evicted", logger.Fields{"messages": 3})
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	Equal(t, err, nil)
+	Equal(t, line["level"], "error")
+	Equal(t, line["subsystem"], "gc")
+	Equal(t, line["msg"], "evicted")
+	Equal(t, line["messages"], float64(3))
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	levels, err := logger.ParseSubsystemLevels("http=debug, gc=error")
+	Equal(t, err, nil)
+
+	var buf bytes.Buffer
+	logger.Configure(logger.Config{Output: &buf, Level: logger.InfoLevel, Subsystems: levels})
+	defer logger.Configure(logger.Config{})
+
+	Equal(t, logger.Enabled("http", logger.DebugLevel), true)
+	Equal(t, logger.Enabled("gc", logger.InfoLevel), false)
+	Equal(t, logger.Enabled("smtp", logger.InfoLevel), true)
+	Equal(t, logger.Enabled("smtp", logger.DebugLevel), false)
+
+	_, err = logger.ParseSubsystemLevels("http")
+	NotEqual(t, err, nil)
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger.Configure(logger.Config{Output: &buf})
+	defer logger.Configure(logger.Config{})
+
+	ctx := logger.NewContext(context.Background(), logger.New("http").With(logger.Fields{"request_id": "abc"}))
+	logger.FromContext(ctx, "smtp").Info("hello", nil)
+	MatchRegex(t, buf.String(), `subsystem=smtp msg=hello request_id=abc`)
+
+	buf.Reset()
+	logger.FromContext(context.Background(), "smtp").Info("hello", nil)
+	NotMatchRegex(t, buf.String(), `request_id`)
+}
@@ -0,0 +1,96 @@
+package mailbox
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Registries and their mailboxes read it to date messages, apply the InboundRate setting and
+// decide which messages have expired.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the wall clock.
+var SystemClock Clock = systemClock{}
+
+// ManualClock is a Clock that tests can control. It follows the wall clock, shifted by however far it has been set or
+// advanced, until it's frozen; a frozen clock only moves when it's set or advanced.
+type ManualClock struct {
+	sync.Mutex
+	offset time.Duration
+	frozen bool
+	at     time.Time
+}
+
+// NewManualClock creates a ManualClock showing the current time and running.
+func NewManualClock() *ManualClock {
+	return &ManualClock{}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now()
+}
+
+func (c *ManualClock) now() time.Time {
+	if c.frozen {
+		return c.at
+	}
+	return time.Now().Add(c.offset)
+}
+
+// Frozen reports whether the clock is frozen.
+func (c *ManualClock) Frozen() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.frozen
+}
+
+// Freeze stops the clock at the time it shows.
+func (c *ManualClock) Freeze() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	c.at = c.now()
+	c.frozen = true
+	return c.at
+}
+
+// Resume starts a frozen clock again from the time it shows.
+func (c *ManualClock) Resume() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if c.frozen {
+		c.offset = c.at.Sub(time.Now())
+		c.frozen = false
+	}
+	return c.now()
+}
+
+// Set moves the clock to t, forwards or backwards.
+func (c *ManualClock) Set(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if c.frozen {
+		c.at = t
+		return
+	}
+	c.offset = t.Sub(time.Now())
+}
+
+// Advance moves the clock forwards by d, or backwards when d is negative.
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if c.frozen {
+		c.at = c.at.Add(d)
+	} else {
+		c.offset += d
+	}
+	return c.now()
+}
@@ -3,45 +3,289 @@ package mailbox
 import (
 	"container/list"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/brettbuddin/ponyexpress/ratelimit"
 )
 
 type Message struct {
 	ID       string    `json:"id"`
+	Seq      uint64    `json:"seq"`
 	Sender   string    `json:"sender"`
 	Subject  string    `json:"subject"`
 	Body     string    `json:"body"`
 	Received time.Time `json:"received"`
+	Seen     bool      `json:"seen"`
+	Flagged  bool      `json:"flagged"`
+	Tags     []string  `json:"tags,omitempty"`
+
+	// Pinned messages, such as fixtures, are never evicted or dropped to keep the mailbox within the SizeLimit setting.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// Headers holds the header fields, other than From and Subject, of messages that were imported from an archive.
+	Headers map[string][]string `json:"headers,omitempty"`
+
+	// imported is when the message was imported, if it was. Imported messages expire relative to it rather than to
+	// Received, which may be long past.
+	imported time.Time
+}
+
+// expires returns the time the message's age is measured from.
+func (m *Message) expires() time.Time {
+	if !m.imported.IsZero() {
+		return m.imported
+	}
+	return m.Received
 }
 
 func (m *Message) Key() string {
 	return m.ID
 }
 
-func NewMailbox(id string, dirty chan *Mailbox) *Mailbox {
+// Size is the number of bytes of content carried by the message.
+func (m *Message) Size() int {
+	size := len(m.Sender) + len(m.Subject) + len(m.Body)
+	for k, values := range m.Headers {
+		for _, v := range values {
+			size += len(k) + len(v)
+		}
+	}
+	return size
+}
+
+// HasTag reports whether the message carries a tag.
+func (m *Message) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageUpdate describes a change to the state of a message. Nil fields are left untouched. Tags replaces the whole
+// set of tags before AddTags and RemoveTags are applied.
+type MessageUpdate struct {
+	Seen       *bool
+	Flagged    *bool
+	Tags       []string
+	AddTags    []string
+	RemoveTags []string
+}
+
+func (u MessageUpdate) apply(m *Message) {
+	if u.Seen != nil {
+		m.Seen = *u.Seen
+	}
+	if u.Flagged != nil {
+		m.Flagged = *u.Flagged
+	}
+	add := u.AddTags
+	if u.Tags != nil {
+		m.Tags = nil
+		add = append(append([]string{}, u.Tags...), u.AddTags...)
+	}
+	for _, t := range add {
+		if !m.HasTag(t) {
+			m.Tags = append(m.Tags, t)
+		}
+	}
+	for _, t := range u.RemoveTags {
+		for i, existing := range m.Tags {
+			if existing == t {
+				m.Tags = append(m.Tags[:i], m.Tags[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Filter selects messages when listing a mailbox.
+type Filter func(*Message) bool
+
+// SeenFilter selects messages that have (or have not) been seen.
+func SeenFilter(seen bool) Filter {
+	return func(m *Message) bool { return m.Seen == seen }
+}
+
+// FlaggedFilter selects messages that are (or are not) flagged.
+func FlaggedFilter(flagged bool) Filter {
+	return func(m *Message) bool { return m.Flagged == flagged }
+}
+
+// TagFilter selects messages carrying a tag.
+func TagFilter(tag string) Filter {
+	return func(m *Message) bool { return m.HasTag(tag) }
+}
+
+// Options configure a mailbox as it's created.
+type Options struct {
+	// MaxMessageSize overrides the MaxMessageSize setting, as SetMaxSize does. Zero means no override.
+	MaxMessageSize int
+}
+
+// NewMailbox creates a Mailbox that belongs to no Registry, so nothing evicts its expired messages unless Evict is
+// called.
+func NewMailbox(id string) *Mailbox {
 	return &Mailbox{
 		ID:    id,
 		list:  newIndexedList(),
-		dirty: dirty,
+		clock: SystemClock,
 	}
 }
 
 type Mailbox struct {
 	sync.RWMutex
-	ID    string `json:"id"`
-	list  *indexedList
-	dirty chan *Mailbox
+	ID             string `json:"id"`
+	list           *indexedList
+	shard          *shard
+	removed        bool // guarded by shard.dirtyMu
+	seq            uint64
+	maxMessageSize int
+	inbound        ratelimit.Bucket
+	clock          Clock
+}
+
+// RateLimitError is returned by Push when a mailbox receives messages faster than the InboundRate setting allows.
+type RateLimitError struct {
+	ratelimit.Result
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("mailbox is receiving messages too quickly: retry in %s", e.RetryAfter)
 }
 
-func (b *Mailbox) Push(m *Message) {
+// MaxSize is the largest message, in bytes, the mailbox will accept. Mailboxes without an override of their own fall
+// back to the MaxMessageSize setting.
+func (b *Mailbox) MaxSize() int {
+	b.RLock()
+	defer b.RUnlock()
+	return b.maxSize()
+}
+
+// SetMaxSize overrides the MaxMessageSize setting for this mailbox. A size of zero removes the override.
+func (b *Mailbox) SetMaxSize(size int) {
 	b.Lock()
 	defer b.Unlock()
-	if b.list.Len() > SizeLimit {
-		b.list.Remove(b.list.Front())
+	b.maxMessageSize = size
+}
+
+func (b *Mailbox) maxSize() int {
+	if b.maxMessageSize > 0 {
+		return b.maxMessageSize
+	}
+	return CurrentSettings().MaxMessageSize
+}
+
+// Push adds a message to the mailbox and assigns it the next sequence number. Sequence numbers are never reused, so
+// gaps between them show where messages were removed. Messages arriving faster than the InboundRate setting allows are
+// refused with a *RateLimitError.
+func (b *Mailbox) Push(m *Message) error {
+	b.Lock()
+	err := b.accept(m)
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+	b.changed()
+	return nil
+}
+
+// accept checks a message against the size and rate limits and pushes it. The caller must hold the lock.
+func (b *Mailbox) accept(m *Message) error {
+	if m.Size() > b.maxSize() {
+		return ErrMessageTooLarge
+	}
+	if s := CurrentSettings(); s.InboundRate > 0 {
+		if r := b.inbound.Take(b.clock.Now(), s.InboundRate, s.InboundBurst); !r.Allowed {
+			return &RateLimitError{r}
+		}
+	}
+	b.push(m)
+	return nil
+}
+
+// changed tells the Registry the mailbox has new messages to consider for eviction. It must be called without the
+// lock held.
+func (b *Mailbox) changed() {
+	if b.shard != nil {
+		b.shard.markDirty(b)
+	}
+}
+
+// Import adds a batch of messages, such as the contents of an archive, in the order they were received. It's all or
+// nothing: if any message is too large or shares an ID with a message already in the mailbox, none are added, and if
+// the mailbox would go over the SizeLimit setting it fails with ErrMailboxFull rather than drop messages. Imports
+// aren't subject to the InboundRate setting, and imported messages expire ExpireAfter after they were imported.
+//
+// Imported messages are added after the messages already in the mailbox and numbered after them, whenever they were
+// received, so sequence numbers and cursors list them as newer than mail that arrived before the import.
+func (b *Mailbox) Import(messages []*Message) error {
+	b.Lock()
+	err := b.importMessages(messages)
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		b.changed()
+	}
+	return nil
+}
+
+func (b *Mailbox) importMessages(messages []*Message) error {
+	if b.list.Len()+len(messages) > CurrentSettings().SizeLimit {
+		return ErrMailboxFull
+	}
+	ids := map[string]bool{}
+	for _, m := range messages {
+		if m.Size() > b.maxSize() {
+			return ErrMessageTooLarge
+		}
+		if _, ok := b.list.GetKey(m.ID); ok || ids[m.ID] {
+			return fmt.Errorf("duplicate message: %s", m.ID)
+		}
+		ids[m.ID] = true
 	}
+
+	now := b.clock.Now()
+	sorted := append([]*Message(nil), messages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Received.Before(sorted[j].Received) })
+	for _, m := range sorted {
+		m.imported = now
+		b.push(m)
+	}
+	return nil
+}
+
+// push appends a message, dropping the oldest unpinned message when the mailbox is full. The caller must hold the
+// lock.
+func (b *Mailbox) push(m *Message) {
+	if b.list.Len() > CurrentSettings().SizeLimit {
+		for e := b.list.Front(); e != nil; e = e.Next() {
+			if !e.Value.(*Message).Pinned {
+				b.list.Remove(e)
+				droppedTotal.Inc()
+				break
+			}
+		}
+	}
+	b.seq++
+	m.Seq = b.seq
 	b.list.PushBack(m)
-	b.dirty <- b
+}
+
+// Messages returns every message in the mailbox, oldest first.
+func (b *Mailbox) Messages() []*Message {
+	b.RLock()
+	defer b.RUnlock()
+	messages := make([]*Message, 0, b.list.Len())
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		messages = append(messages, e.Value.(*Message))
+	}
+	return messages
 }
 
 func (b *Mailbox) Get(id string) (*Message, error) {
@@ -53,6 +297,22 @@ func (b *Mailbox) Get(id string) (*Message, error) {
 	return nil, fmt.Errorf("unknown message: %s", id)
 }
 
+// Update changes the state of a message. The stored message is replaced by an updated copy so that messages already
+// handed out to readers are never modified underneath them.
+func (b *Mailbox) Update(id string, u MessageUpdate) (*Message, error) {
+	b.Lock()
+	defer b.Unlock()
+	e, ok := b.list.GetKey(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown message: %s", id)
+	}
+	msg := *e.Value.(*Message)
+	msg.Tags = append([]string(nil), msg.Tags...)
+	u.apply(&msg)
+	e.Value = &msg
+	return &msg, nil
+}
+
 func (b *Mailbox) Remove(id string) (*Message, error) {
 	b.Lock()
 	defer b.Unlock()
@@ -62,35 +322,67 @@ func (b *Mailbox) Remove(id string) (*Message, error) {
 	return nil, fmt.Errorf("unknown message: %s", id)
 }
 
-func (b *Mailbox) List(sinceID string, limit int) []*Message {
+// RemoveMany removes a batch of messages. The result lines up with ids; messages that could not be found are nil.
+func (b *Mailbox) RemoveMany(ids []string) []*Message {
 	b.Lock()
 	defer b.Unlock()
-	messages := []*Message{}
-
-	var e *list.Element
-	if sinceID != "" {
-		var ok bool
-		e, ok = b.list.GetKey(sinceID)
-		if ok {
-			e = e.Next()
+	removed := make([]*Message, len(ids))
+	for i, id := range ids {
+		if e, ok := b.list.GetKey(id); ok {
+			removed[i] = b.list.Remove(e).(*Message)
 		}
-	} else {
-		e = b.list.Front()
 	}
+	return removed
+}
 
-	count := 0
-	for ; e != nil; e = e.Next() {
-		if count >= limit {
-			break
-		}
+// Purge removes every message matched by all of the filters, or every message when there are none. Removed messages
+// are returned newest first.
+func (b *Mailbox) Purge(filters ...Filter) []*Message {
+	b.Lock()
+	defer b.Unlock()
+	removed := []*Message{}
+	var prev *list.Element
+	for e := b.list.Back(); e != nil; e = prev {
+		prev = e.Prev()
 		msg := e.Value.(*Message)
-		messages = append([]*Message{msg}, messages...)
-		count++
+		if matches(msg, filters) {
+			b.list.Remove(e)
+			removed = append(removed, msg)
+		}
 	}
+	return removed
+}
 
-	return messages
+// List returns up to limit messages newer than sinceID, newest first. Only messages matched by every filter are
+// returned. An unknown sinceID, such as one that has since been evicted, lists from the oldest retained message.
+func (b *Mailbox) List(sinceID string, limit int, filters ...Filter) []*Message {
+	return b.Page(Query{
+		After:   &Cursor{ID: sinceID},
+		Limit:   limit,
+		Filters: filters,
+	}).Messages
+}
+
+func matches(m *Message, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats counts the messages in the mailbox and the bytes of content they carry.
+func (b *Mailbox) Stats() (messages, bytes int) {
+	b.RLock()
+	defer b.RUnlock()
+	for e := b.list.Front(); e != nil; e = e.Next() {
+		bytes += e.Value.(*Message).Size()
+	}
+	return b.list.Len(), bytes
 }
 
+// Evict removes the messages, other than pinned ones, that are older than cutoff and returns how many were removed.
 func (b *Mailbox) Evict(cutoff time.Time) int {
 	b.Lock()
 	defer b.Unlock()
@@ -99,7 +391,7 @@ func (b *Mailbox) Evict(cutoff time.Time) int {
 	for e := b.list.Front(); e != nil; e = next {
 		next = e.Next()
 		msg := e.Value.(*Message)
-		if msg.Received.Before(cutoff) {
+		if !msg.Pinned && msg.expires().Before(cutoff) {
 			b.list.Remove(e)
 			evicted++
 		}
@@ -0,0 +1,184 @@
+package mailbox
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The parallel benchmarks show how Registry throughput scales with GOMAXPROCS:
+//
+//	go test ./mailbox -run NONE -bench Parallel -cpu 1,2,4,8
+
+func BenchmarkRegistryCreateParallel(b *testing.B) {
+	r := NewRegistry()
+	defer r.Close()
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Create(strconv.FormatInt(atomic.AddInt64(&n, 1), 10))
+		}
+	})
+}
+
+func BenchmarkRegistryPushParallel(b *testing.B) {
+	r := NewRegistry()
+	defer r.Close()
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+		r.Create(ids[i])
+	}
+	now := time.Now()
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			box, _ := r.Get(ids[i%int64(len(ids))])
+			box.Push(&Message{ID: strconv.FormatInt(i, 10), Sender: "brett@buddin.us", Received: now})
+		}
+	})
+}
+
+func BenchmarkRegistryGetParallel(b *testing.B) {
+	r := NewRegistry()
+	defer r.Close()
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+		r.Create(ids[i])
+	}
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Get(ids[atomic.AddInt64(&n, 1)%int64(len(ids))])
+		}
+	})
+}
+
+// stress runs worker concurrently in each of workers goroutines for iterations rounds, failing the test if they
+// haven't all finished within a deadline, which is taken to mean they're deadlocked.
+func stress(t *testing.T, workers, iterations int, worker func(w, i int)) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				worker(w, i)
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("workers did not finish: deadlock?")
+	}
+}
+
+func TestStressRegistry(t *testing.T) {
+	settings := DefaultSettings()
+	settings.DirtyMax = 1
+	settings.SizeLimit = 20
+	settings.EvictEvery = time.Millisecond
+	settings.ExpireAfter = time.Millisecond
+	Configure(settings)
+	defer Configure(DefaultSettings())
+
+	r := NewRegistry()
+	defer r.Close()
+
+	ids := make([]string, 16)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+
+	stress(t, 16, 500, func(w, i int) {
+		id := ids[(w+i)%len(ids)]
+		switch i % 7 {
+		case 0:
+			r.Create(id)
+		case 1:
+			r.CreateMany([]string{id, ids[(w+i+1)%len(ids)]})
+		case 2:
+			r.Remove(id)
+		case 3:
+			r.Evict()
+		case 4:
+			r.List()
+			r.Stats()
+		default:
+			box, err := r.Get(id)
+			if err != nil {
+				return
+			}
+			box.Push(&Message{ID: fmt.Sprintf("%d-%d", w, i), Sender: "brett@buddin.us", Received: time.Now()})
+			box.List("", 10)
+			box.Evict(time.Now())
+		}
+	})
+}
+
+func TestStressMailbox(t *testing.T) {
+	r := NewRegistry()
+	defer r.Close()
+	a, _ := r.Create("a")
+	b, _ := r.Create("b")
+
+	stress(t, 8, 1000, func(w, i int) {
+		box := a
+		if w%2 == 1 {
+			box = b
+		}
+		id := fmt.Sprintf("%d-%d", w, i)
+		box.Push(&Message{ID: id, Sender: "brett@buddin.us", Received: time.Now()})
+		box.Import([]*Message{{ID: id + "-imported", Sender: "brett@buddin.us", Received: time.Now()}})
+		box.Update(id, MessageUpdate{Tags: []string{"stress"}})
+		box.List("", 10, TagFilter("stress"))
+		box.Page(Query{Limit: 5})
+		box.Get(id)
+		box.Remove(id)
+		box.Evict(time.Now().Add(-time.Hour))
+	})
+}
+
+func TestPushAfterRemove(t *testing.T) {
+	r := NewRegistry()
+	defer r.Close()
+	box, _ := r.Create("a")
+	r.Remove("a")
+
+	box.Push(&Message{ID: "1", Sender: "brett@buddin.us", Received: time.Now()})
+	if n := len(box.shard.takeDirty()); n != 0 {
+		t.Fatalf("removed mailbox marked dirty: %d", n)
+	}
+}
+
+func TestPushAfterClose(t *testing.T) {
+	r := NewRegistry()
+	box, _ := r.Create("a")
+	r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		box.Push(&Message{ID: "1", Sender: "brett@buddin.us", Received: time.Now()})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push after close blocked")
+	}
+}
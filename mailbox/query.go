@@ -0,0 +1,196 @@
+package mailbox
+
+import (
+	"container/list"
+	"time"
+)
+
+// Cursor marks a position in a mailbox. When the message it was taken from is no longer in the mailbox the position
+// is recovered from its sequence number or, failing that, the time it was received.
+type Cursor struct {
+	ID       string
+	Seq      uint64
+	Received time.Time
+}
+
+// CursorFor returns a Cursor positioned at a message.
+func CursorFor(m *Message) *Cursor {
+	return &Cursor{ID: m.ID, Seq: m.Seq, Received: m.Received}
+}
+
+// Order is the order messages are returned in.
+type Order int
+
+const (
+	Descending Order = iota
+	Ascending
+)
+
+// Query describes a page of messages. Before selects the messages immediately older than a cursor; otherwise the
+// messages immediately newer than After are selected, starting from the oldest message when After is unset.
+type Query struct {
+	After   *Cursor
+	Before  *Cursor
+	Limit   int
+	Order   Order
+	Filters []Filter
+}
+
+// Page is the result of a Query.
+type Page struct {
+	Messages []*Message
+
+	// More reports whether there are messages beyond the page in the direction it was read.
+	More bool
+
+	// Dropped counts the messages that came after the After cursor but were removed before the oldest message still
+	// in the mailbox.
+	Dropped uint64
+}
+
+// Page returns the messages described by a Query.
+func (b *Mailbox) Page(q Query) *Page {
+	b.RLock()
+	defer b.RUnlock()
+
+	var (
+		e    *list.Element
+		step func(*list.Element) *list.Element
+	)
+	if q.Before != nil {
+		e, step = b.seekBefore(q.Before), (*list.Element).Prev
+	} else {
+		e, step = b.seekAfter(q.After), (*list.Element).Next
+	}
+
+	page := &Page{Messages: make([]*Message, 0, capacity(q.Limit, b.span(e, q.Before == nil)))}
+	if q.Before == nil && q.After != nil {
+		page.Dropped = b.dropped(q.After)
+	}
+	for ; e != nil; e = step(e) {
+		msg := e.Value.(*Message)
+		if !matches(msg, q.Filters) {
+			continue
+		}
+		if len(page.Messages) >= q.Limit {
+			page.More = true
+			break
+		}
+		page.Messages = append(page.Messages, msg)
+	}
+
+	// Messages were collected oldest first when walking forward and newest first when walking backward.
+	if (q.Before == nil) == (q.Order == Descending) {
+		reverse(page.Messages)
+	}
+	return page
+}
+
+// seekAfter finds the first element newer than a cursor.
+func (b *Mailbox) seekAfter(c *Cursor) *list.Element {
+	if c == nil {
+		return b.list.Front()
+	}
+	if e, ok := b.list.GetKey(c.ID); c.ID != "" && ok {
+		return e.Next()
+	}
+	if c.Seq > 0 {
+		return b.seekSeq(c.Seq)
+	}
+	e := b.list.Front()
+	if !c.Received.IsZero() {
+		for ; e != nil && !e.Value.(*Message).Received.After(c.Received); e = e.Next() {
+		}
+	}
+	return e
+}
+
+// seekBefore finds the first element older than a cursor.
+func (b *Mailbox) seekBefore(c *Cursor) *list.Element {
+	if e, ok := b.list.GetKey(c.ID); c.ID != "" && ok {
+		return e.Prev()
+	}
+	if c.Seq > 0 {
+		if e := b.seekSeq(c.Seq - 1); e != nil {
+			return e.Prev()
+		}
+		return b.list.Back()
+	}
+	e := b.list.Back()
+	if !c.Received.IsZero() {
+		for ; e != nil && !e.Value.(*Message).Received.Before(c.Received); e = e.Prev() {
+		}
+	}
+	return e
+}
+
+// seekSeq finds the first element with a sequence number greater than seq. Sequence numbers increase from the front
+// of the list to the back, so it walks from whichever end is nearer: cursors from clients keeping up with a large
+// mailbox are usually close to the back.
+func (b *Mailbox) seekSeq(seq uint64) *list.Element {
+	front, back := b.list.Front(), b.list.Back()
+	if back == nil || back.Value.(*Message).Seq <= seq {
+		return nil
+	}
+	first := front.Value.(*Message).Seq
+	if first > seq {
+		return front
+	}
+	if seq-first < back.Value.(*Message).Seq-seq {
+		e := front
+		for ; e.Value.(*Message).Seq <= seq; e = e.Next() {
+		}
+		return e
+	}
+	e := back
+	for p := e.Prev(); p != nil && p.Value.(*Message).Seq > seq; p = p.Prev() {
+		e = p
+	}
+	return e
+}
+
+// span bounds the number of elements from e to the end of the list in one direction by their sequence numbers, without
+// walking them.
+func (b *Mailbox) span(e *list.Element, forward bool) int {
+	if e == nil {
+		return 0
+	}
+	seq := e.Value.(*Message).Seq
+	if forward {
+		return int(b.list.Back().Value.(*Message).Seq-seq) + 1
+	}
+	return int(seq-b.list.Front().Value.(*Message).Seq) + 1
+}
+
+// dropped counts the messages between a cursor and the oldest message in the mailbox that are no longer around.
+func (b *Mailbox) dropped(c *Cursor) uint64 {
+	if c.Seq == 0 || c.Seq >= b.seq {
+		return 0
+	}
+	front := b.list.Front()
+	if front == nil {
+		// Everything after the cursor up to the last sequence number handed out is gone.
+		return b.seq - c.Seq
+	}
+	oldest := front.Value.(*Message).Seq
+	if oldest <= c.Seq+1 {
+		return 0
+	}
+	return oldest - c.Seq - 1
+}
+
+func capacity(limit, size int) int {
+	if limit < 0 {
+		return 0
+	}
+	if limit < size {
+		return limit
+	}
+	return size
+}
+
+func reverse(messages []*Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
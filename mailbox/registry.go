@@ -2,105 +2,345 @@ package mailbox
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/metrics"
 )
 
+var gcLog = logger.New("gc")
+
 var (
-	SizeLimit   = 500
-	ExpireAfter = time.Hour
+	evictedTotal = metrics.NewCounter(
+		"ponyexpress_messages_evicted_total",
+		"Number of messages evicted for being older than the ExpireAfter setting.",
+	)
+	droppedTotal = metrics.NewCounter(
+		"ponyexpress_messages_dropped_total",
+		"Number of messages dropped to keep mailboxes within the SizeLimit setting.",
+	)
 )
 
+var ErrMessageTooLarge = fmt.Errorf("message too large")
+
+// ErrMailboxFull is returned by Import when the mailbox can't hold every message without dropping some to stay within
+// the SizeLimit setting.
+var ErrMailboxFull = fmt.Errorf("mailbox full")
+
+// shardCount is the number of partitions a Registry spreads its mailboxes over. Each has its own lock and eviction
+// goroutine, so mailboxes in different shards never wait on each other.
+const shardCount = 32
+
 func NewRegistry() *Registry {
+	return NewRegistryWithClock(SystemClock)
+}
+
+// NewRegistryWithClock creates a Registry whose mailboxes tell the time, and decide when messages expire, by clock.
+func NewRegistryWithClock(clock Clock) *Registry {
 	r := &Registry{
-		boxes: map[string]*Mailbox{},
-		dirty: make(chan *Mailbox),
+		shards: make([]*shard, shardCount),
+		clock:  clock,
+	}
+	for i := range r.shards {
+		sh := &shard{
+			boxes: map[string]*Mailbox{},
+			dirty: map[*Mailbox]struct{}{},
+			wake:  make(chan struct{}, 1),
+			quit:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		r.shards[i] = sh
+		go r.eviction(sh)
 	}
-	go r.eviction()
 	return r
 }
 
+// Registry holds mailboxes, hash-partitioned by ID into shards.
 type Registry struct {
+	shards    []*shard
+	closeOnce sync.Once
+	clock     Clock
+}
+
+// shard is a partition of a Registry's mailboxes. Mailboxes that receive messages are added to its dirty set, which
+// its eviction goroutine empties. The dirty set has a lock of its own and nothing waits on the eviction goroutine, so
+// pushes never block on eviction, nor eviction on pushes.
+type shard struct {
 	sync.RWMutex
 	boxes map[string]*Mailbox
-	dirty chan *Mailbox
+
+	dirtyMu sync.Mutex
+	dirty   map[*Mailbox]struct{}
+
+	// wake asks the eviction goroutine for an early pass once there are more than DirtyMax dirty mailboxes.
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+// markDirty records that a mailbox has received messages. Mailboxes that have been removed from the Registry are
+// ignored.
+func (sh *shard) markDirty(b *Mailbox) {
+	sh.dirtyMu.Lock()
+	if b.removed {
+		sh.dirtyMu.Unlock()
+		return
+	}
+	sh.dirty[b] = struct{}{}
+	n := len(sh.dirty)
+	sh.dirtyMu.Unlock()
+
+	if n > CurrentSettings().DirtyMax {
+		select {
+		case sh.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// takeDirty empties the dirty set and returns what it held.
+func (sh *shard) takeDirty() map[*Mailbox]struct{} {
+	sh.dirtyMu.Lock()
+	defer sh.dirtyMu.Unlock()
+	dirty := sh.dirty
+	sh.dirty = map[*Mailbox]struct{}{}
+	return dirty
+}
+
+// forget marks a mailbox removed and drops it from the dirty set.
+func (sh *shard) forget(b *Mailbox) {
+	sh.dirtyMu.Lock()
+	defer sh.dirtyMu.Unlock()
+	b.removed = true
+	delete(sh.dirty, b)
+}
+
+// shardIndex picks the shard a mailbox belongs to by the FNV-1a hash of its ID.
+func shardIndex(id string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return int(h % shardCount)
+}
+
+func (r *Registry) shard(id string) *shard {
+	return r.shards[shardIndex(id)]
 }
 
+// Clock returns the Clock the Registry tells the time by.
+func (r *Registry) Clock() Clock {
+	return r.clock
+}
+
+func (r *Registry) newMailbox(sh *shard, id string, opts Options) *Mailbox {
+	b := NewMailbox(id)
+	b.maxMessageSize = opts.MaxMessageSize
+	b.shard = sh
+	b.clock = r.clock
+	return b
+}
+
+// Close stops the eviction goroutines and waits for them to exit. Messages pushed into the Registry's mailboxes
+// afterwards are kept until they're deleted, since nothing evicts them. Closing a Registry more than once has no
+// effect.
 func (r *Registry) Close() {
-	close(r.dirty)
+	r.closeOnce.Do(func() {
+		for _, sh := range r.shards {
+			close(sh.quit)
+		}
+	})
+	for _, sh := range r.shards {
+		<-sh.done
+	}
 }
 
 func (r *Registry) Create(id string) (*Mailbox, error) {
-	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.boxes[id]; ok {
+	return r.CreateWithOptions(id, Options{})
+}
+
+// CreateWithOptions creates a mailbox configured by opts. The mailbox isn't visible to other callers until it's fully
+// configured.
+func (r *Registry) CreateWithOptions(id string, opts Options) (*Mailbox, error) {
+	sh := r.shard(id)
+	sh.Lock()
+	defer sh.Unlock()
+	if _, ok := sh.boxes[id]; ok {
 		return nil, fmt.Errorf("mailbox already exists: %s", id)
 	}
-	b := NewMailbox(id, r.dirty)
-	r.boxes[id] = b
+	b := r.newMailbox(sh, id, opts)
+	sh.boxes[id] = b
 	return b, nil
 }
 
+// CreateMany creates a batch of mailboxes. Either all of them are created or, if any of the ids is already taken,
+// none are.
+func (r *Registry) CreateMany(ids []string) ([]*Mailbox, error) {
+	return r.CreateManyWithOptions(ids, nil)
+}
+
+// CreateManyWithOptions creates a batch of mailboxes like CreateMany, configuring each by the Options at the same
+// index in opts. Mailboxes beyond the end of opts get the zero Options.
+func (r *Registry) CreateManyWithOptions(ids []string, opts []Options) ([]*Mailbox, error) {
+	seen := map[string]struct{}{}
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			return nil, fmt.Errorf("duplicate mailbox: %s", id)
+		}
+		seen[id] = struct{}{}
+	}
+
+	// Lock every shard involved, in index order so concurrent batches can't deadlock.
+	var indexes []int
+	locked := map[int]bool{}
+	for _, id := range ids {
+		if i := shardIndex(id); !locked[i] {
+			locked[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		r.shards[i].Lock()
+		defer r.shards[i].Unlock()
+	}
+
+	for _, id := range ids {
+		if _, ok := r.shard(id).boxes[id]; ok {
+			return nil, fmt.Errorf("mailbox already exists: %s", id)
+		}
+	}
+	boxes := make([]*Mailbox, len(ids))
+	for i, id := range ids {
+		sh := r.shard(id)
+		var o Options
+		if i < len(opts) {
+			o = opts[i]
+		}
+		boxes[i] = r.newMailbox(sh, id, o)
+		sh.boxes[id] = boxes[i]
+	}
+	return boxes, nil
+}
+
 func (r *Registry) Get(id string) (*Mailbox, error) {
-	r.RLock()
-	defer r.RUnlock()
-	b, ok := r.boxes[id]
+	sh := r.shard(id)
+	sh.RLock()
+	defer sh.RUnlock()
+	b, ok := sh.boxes[id]
 	if !ok {
 		return nil, fmt.Errorf("unknown mailbox: %s", id)
 	}
 	return b, nil
 }
 
+// List returns every mailbox, ordered by ID.
+func (r *Registry) List() []*Mailbox {
+	boxes := r.all()
+	sort.Slice(boxes, func(i, j int) bool { return boxes[i].ID < boxes[j].ID })
+	return boxes
+}
+
+// all returns every mailbox in no particular order. Each shard is read under its own lock, so mailboxes created or
+// removed meanwhile may or may not be included.
+func (r *Registry) all() []*Mailbox {
+	var boxes []*Mailbox
+	for _, sh := range r.shards {
+		sh.RLock()
+		for _, b := range sh.boxes {
+			boxes = append(boxes, b)
+		}
+		sh.RUnlock()
+	}
+	return boxes
+}
+
+// Stats summarizes the contents of a Registry.
+type Stats struct {
+	Mailboxes int
+	Messages  int
+	Bytes     int
+}
+
+// Stats counts the mailboxes in the registry and the messages they hold.
+func (r *Registry) Stats() Stats {
+	boxes := r.all()
+	stats := Stats{Mailboxes: len(boxes)}
+	for _, b := range boxes {
+		messages, bytes := b.Stats()
+		stats.Messages += messages
+		stats.Bytes += bytes
+	}
+	return stats
+}
+
+// Remove takes a mailbox out of the Registry. Messages pushed into it afterwards are no longer considered for eviction.
 func (r *Registry) Remove(id string) (*Mailbox, error) {
-	r.Lock()
-	defer r.Unlock()
-	box, ok := r.boxes[id]
+	sh := r.shard(id)
+	sh.Lock()
+	box, ok := sh.boxes[id]
+	if ok {
+		delete(sh.boxes, id)
+	}
+	sh.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("unknown mailbox: %s", id)
 	}
-	delete(r.boxes, id)
+	sh.forget(box)
 	return box, nil
 }
 
-var (
-	DirtyMax   = 100
-	EvictEvery = 30 * time.Second
-)
+// Evict removes the messages older than the ExpireAfter setting from every mailbox right away, rather than waiting for
+// the eviction goroutine to get round to them, and returns how many were removed.
+func (r *Registry) Evict() int {
+	expire := r.clock.Now().Add(-CurrentSettings().ExpireAfter)
+	evicted := 0
+	for _, mb := range r.all() {
+		evicted += mb.Evict(expire)
+	}
+	evictedTotal.Add(float64(evicted))
+	gcLog.Debug("evicted", logger.Fields{"older_than": expire, "messages": evicted})
+	return evicted
+}
 
-func (r *Registry) eviction() {
-	var (
-		dirty = map[*Mailbox]struct{}{}
-		evict = func() {
-			logger.Debugf("gc: started")
-			expire := time.Now().Add(-ExpireAfter)
-			logger.Debugf("gc: evicting messages older than %s", expire)
-			for mb := range dirty {
-				evicted := mb.Evict(expire)
-				logger.Debugf("gc: evicted %d messages from %s\n", evicted, mb.ID)
-			}
-			dirty = map[*Mailbox]struct{}{}
-			logger.Debugf("gc: completed")
+// eviction evicts expired messages from a shard's dirty mailboxes every EvictEvery, or sooner once more than DirtyMax
+// of them have received messages.
+func (r *Registry) eviction(sh *shard) {
+	defer close(sh.done)
+
+	evict := func() {
+		dirty := sh.takeDirty()
+		if len(dirty) == 0 {
+			return
 		}
-		tick = time.Tick(EvictEvery)
-	)
+		gcLog.Debug("started", nil)
+		expire := r.clock.Now().Add(-CurrentSettings().ExpireAfter)
+		gcLog.Debug("evicting", logger.Fields{"older_than": expire})
+		for mb := range dirty {
+			evicted := mb.Evict(expire)
+			evictedTotal.Add(float64(evicted))
+			gcLog.Debug("evicted", logger.Fields{"mailbox": mb.ID, "messages": evicted})
+		}
+		gcLog.Debug("completed", nil)
+	}
+
+	every := CurrentSettings().EvictEvery
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case mailbox, ok := <-r.dirty:
-			if !ok {
-				return
-			}
-			if _, ok := dirty[mailbox]; !ok {
-				dirty[mailbox] = struct{}{}
-			}
-			if len(dirty) > DirtyMax {
-				evict()
-			}
-		case <-tick:
-			if len(dirty) == 0 {
-				continue
+		case <-sh.quit:
+			return
+		case <-sh.wake:
+			evict()
+		case <-ticker.C:
+			if e := CurrentSettings().EvictEvery; e != every {
+				every = e
+				ticker.Reset(every)
 			}
 			evict()
 		}
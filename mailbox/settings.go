@@ -0,0 +1,58 @@
+package mailbox
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Settings control retention and size limits for every Registry. They may be changed with Configure while registries
+// are in use.
+type Settings struct {
+	// SizeLimit is the number of messages a mailbox holds before the oldest are dropped.
+	SizeLimit int
+
+	// ExpireAfter is how long messages are kept.
+	ExpireAfter time.Duration
+
+	// MaxMessageSize is the largest message, in bytes, accepted by mailboxes without a limit of their own.
+	MaxMessageSize int
+
+	// EvictEvery is how often mailboxes that have received messages are checked for expired messages.
+	EvictEvery time.Duration
+
+	// DirtyMax is the number of mailboxes in a Registry shard that may receive messages before an eviction pass over
+	// the shard is forced.
+	DirtyMax int
+
+	// InboundRate is the number of messages per second each mailbox accepts once InboundBurst messages have been
+	// received at once. Zero means no limit.
+	InboundRate  float64
+	InboundBurst int
+}
+
+// DefaultSettings returns the Settings in effect until Configure is called.
+func DefaultSettings() Settings {
+	return Settings{
+		SizeLimit:      500,
+		ExpireAfter:    time.Hour,
+		MaxMessageSize: 10 << 20,
+		EvictEvery:     30 * time.Second,
+		DirtyMax:       100,
+	}
+}
+
+var settings atomic.Value
+
+func init() {
+	settings.Store(DefaultSettings())
+}
+
+// Configure replaces the Settings.
+func Configure(s Settings) {
+	settings.Store(s)
+}
+
+// CurrentSettings returns the Settings in effect.
+func CurrentSettings() Settings {
+	return settings.Load().(Settings)
+}
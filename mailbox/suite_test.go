@@ -3,6 +3,7 @@ package mailbox
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -79,6 +80,90 @@ func (s Suite) BenchmarkMessageGetNearFirstElement(c *check.C) {
 	}
 }
 
+// largeMailbox fills a mailbox with n messages, every tenth of them seen, raising the SizeLimit setting so none are
+// dropped. The caller restores the default settings.
+func (s Suite) largeMailbox(n int) *Mailbox {
+	settings := DefaultSettings()
+	settings.SizeLimit = n
+	Configure(settings)
+
+	box, _ := s.registry.Create("large")
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		box.Push(&Message{
+			ID:       strconv.Itoa(i),
+			Received: now,
+			Seen:     i%10 == 0,
+		})
+	}
+	return box
+}
+
+func (s Suite) BenchmarkList10kNewest(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.Page(Query{Before: &Cursor{}, Limit: 100})
+	}
+}
+
+func (s Suite) BenchmarkList10kFromStart(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.List("", 100)
+	}
+}
+
+func (s Suite) BenchmarkList10kSinceNearEnd(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.List("9950", 100)
+	}
+}
+
+func (s Suite) BenchmarkList10kSinceMiddle(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.List("5000", 100)
+	}
+}
+
+func (s Suite) BenchmarkList10kEvictedCursorNearEnd(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	cursor := &Cursor{ID: "gone", Seq: 9950}
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.Page(Query{After: cursor, Limit: 100})
+	}
+}
+
+func (s Suite) BenchmarkList10kEvictedCursorBefore(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	cursor := &Cursor{ID: "gone", Seq: 100}
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.Page(Query{Before: cursor, Limit: 100})
+	}
+}
+
+func (s Suite) BenchmarkList10kFiltered(c *check.C) {
+	box := s.largeMailbox(10000)
+	defer Configure(DefaultSettings())
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		box.List("", 100, SeenFilter(true))
+	}
+}
+
 func (s Suite) TestBasicOperations(c *check.C) {
 	// Create a mailbox
 	box, err := s.registry.Create("a")
@@ -131,6 +216,165 @@ func (s Suite) TestBasicOperations(c *check.C) {
 	c.Assert(err, check.NotNil)
 }
 
+func (s Suite) TestMaxMessageSize(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	c.Assert(b.MaxSize(), check.Equals, CurrentSettings().MaxMessageSize)
+
+	b.SetMaxSize(10)
+	c.Assert(b.MaxSize(), check.Equals, 10)
+
+	err = b.Push(&Message{ID: "small", Body: "1234567890"})
+	c.Assert(err, check.IsNil)
+
+	err = b.Push(&Message{ID: "large", Body: "12345678901"})
+	c.Assert(err, check.Equals, ErrMessageTooLarge)
+
+	_, err = b.Get("large")
+	c.Assert(err, check.NotNil)
+
+	b.SetMaxSize(0)
+	c.Assert(b.MaxSize(), check.Equals, CurrentSettings().MaxMessageSize)
+}
+
+func (s Suite) TestUpdate(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	original := &Message{ID: "id", Tags: []string{"one"}}
+	b.Push(original)
+
+	yes := true
+	msg, err := b.Update("id", MessageUpdate{
+		Seen:    &yes,
+		AddTags: []string{"two", "one", "three"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Seen, check.Equals, true)
+	c.Assert(msg.Flagged, check.Equals, false)
+	c.Assert(msg.Tags, check.DeepEquals, []string{"one", "two", "three"})
+
+	msg, err = b.Update("id", MessageUpdate{
+		Flagged:    &yes,
+		RemoveTags: []string{"two"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Seen, check.Equals, true)
+	c.Assert(msg.Flagged, check.Equals, true)
+	c.Assert(msg.Tags, check.DeepEquals, []string{"one", "three"})
+
+	msg, err = b.Update("id", MessageUpdate{Tags: []string{}})
+	c.Assert(err, check.IsNil)
+	c.Assert(msg.Tags, check.HasLen, 0)
+
+	stored, err := b.Get("id")
+	c.Assert(err, check.IsNil)
+	c.Assert(stored, check.Equals, msg)
+
+	// Messages already handed out are left alone
+	c.Assert(original.Seen, check.Equals, false)
+	c.Assert(original.Tags, check.DeepEquals, []string{"one"})
+
+	_, err = b.Update("does-not-exist", MessageUpdate{Seen: &yes})
+	c.Assert(err, check.NotNil)
+}
+
+func (s Suite) TestListFilters(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for i := 0; i < 10; i++ {
+		b.Push(&Message{
+			ID:      fmt.Sprintf("id-%d", i),
+			Seen:    i%2 == 0,
+			Flagged: i%3 == 0,
+		})
+	}
+	b.Update("id-3", MessageUpdate{AddTags: []string{"checked"}})
+	b.Update("id-4", MessageUpdate{AddTags: []string{"checked"}})
+
+	c.Assert(b.List("", 100, SeenFilter(true)), check.HasLen, 5)
+	c.Assert(b.List("", 100, SeenFilter(false), FlaggedFilter(true)), check.HasLen, 2)
+	c.Assert(b.List("", 2, FlaggedFilter(true)), check.HasLen, 2)
+
+	messages := b.List("", 100, TagFilter("checked"), SeenFilter(false))
+	c.Assert(messages, check.HasLen, 1)
+	c.Assert(messages[0].ID, check.Equals, "id-3")
+}
+
+func (s Suite) TestCreateMany(c *check.C) {
+	boxes, err := s.registry.CreateMany([]string{"a", "b", "c"})
+	c.Assert(err, check.IsNil)
+	c.Assert(boxes, check.HasLen, 3)
+
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := s.registry.Get(id)
+		c.Assert(err, check.IsNil)
+	}
+
+	// Nothing is created when any of the ids is taken
+	_, err = s.registry.CreateMany([]string{"d", "a"})
+	c.Assert(err, check.NotNil)
+	_, err = s.registry.Get("d")
+	c.Assert(err, check.NotNil)
+
+	_, err = s.registry.CreateMany([]string{"e", "e"})
+	c.Assert(err, check.NotNil)
+	_, err = s.registry.Get("e")
+	c.Assert(err, check.NotNil)
+}
+
+func (s Suite) TestCreateWithOptions(c *check.C) {
+	b, err := s.registry.CreateWithOptions("a", Options{MaxMessageSize: 1024})
+	c.Assert(err, check.IsNil)
+	c.Assert(b.MaxSize(), check.Equals, 1024)
+
+	boxes, err := s.registry.CreateManyWithOptions([]string{"b", "c"}, []Options{{MaxMessageSize: 2048}})
+	c.Assert(err, check.IsNil)
+	c.Assert(boxes[0].MaxSize(), check.Equals, 2048)
+	c.Assert(boxes[1].MaxSize(), check.Equals, CurrentSettings().MaxMessageSize)
+}
+
+func (s Suite) TestRemoveMany(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for i := 0; i < 5; i++ {
+		b.Push(&Message{ID: fmt.Sprintf("id-%d", i)})
+	}
+
+	removed := b.RemoveMany([]string{"id-1", "does-not-exist", "id-3"})
+	c.Assert(removed, check.HasLen, 3)
+	c.Assert(removed[0].ID, check.Equals, "id-1")
+	c.Assert(removed[1], check.IsNil)
+	c.Assert(removed[2].ID, check.Equals, "id-3")
+	c.Assert(b.List("", 100), check.HasLen, 3)
+}
+
+func (s Suite) TestPurge(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	for i := 0; i < 10; i++ {
+		b.Push(&Message{ID: fmt.Sprintf("id-%d", i), Seen: i%2 == 0})
+	}
+
+	removed := b.Purge(SeenFilter(true))
+	c.Assert(removed, check.HasLen, 5)
+	c.Assert(removed[0].ID, check.Equals, "id-8")
+	c.Assert(b.List("", 100), check.HasLen, 5)
+
+	removed = b.Purge()
+	c.Assert(removed, check.HasLen, 5)
+	c.Assert(b.List("", 100), check.HasLen, 0)
+}
+
+func (s Suite) TestCloseTwice(c *check.C) {
+	r := NewRegistry()
+	r.Close()
+	r.Close()
+}
+
 func (s Suite) TestListOrder(c *check.C) {
 	b, err := s.registry.Create("a")
 	c.Assert(err, check.IsNil)
@@ -196,6 +440,151 @@ func (s Suite) TestListCursor(c *check.C) {
 	c.Assert(messages[0].ID, check.Equals, "id-9")
 }
 
+func (s Suite) TestListEvictedCursor(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.Push(&Message{
+			ID:       fmt.Sprintf("id-%d", i),
+			Received: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	b.Evict(now.Add(3 * time.Minute))
+
+	messages := b.List("id-1", 100)
+	c.Assert(messages, check.HasLen, 7)
+	c.Assert(messages[6].ID, check.Equals, "id-3")
+}
+
+func (s Suite) TestPage(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.Push(&Message{
+			ID:       fmt.Sprintf("id-%d", i),
+			Received: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	ids := func(p *Page) []string {
+		out := []string{}
+		for _, m := range p.Messages {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+
+	page := b.Page(Query{After: &Cursor{ID: "id-2"}, Limit: 3})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-5", "id-4", "id-3"})
+	c.Assert(page.More, check.Equals, true)
+
+	page = b.Page(Query{After: &Cursor{ID: "id-2"}, Limit: 3, Order: Ascending})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-3", "id-4", "id-5"})
+
+	page = b.Page(Query{Before: &Cursor{ID: "id-5"}, Limit: 3})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-4", "id-3", "id-2"})
+	c.Assert(page.More, check.Equals, true)
+
+	page = b.Page(Query{Before: &Cursor{ID: "id-2"}, Limit: 3, Order: Ascending})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-0", "id-1"})
+	c.Assert(page.More, check.Equals, false)
+
+	page = b.Page(Query{Before: &Cursor{}, Limit: 2})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-9", "id-8"})
+
+	// Cursors whose message is gone fall back to the time it was received
+	_, err = b.Remove("id-6")
+	c.Assert(err, check.IsNil)
+	gone := &Cursor{ID: "id-6", Received: now.Add(6 * time.Minute)}
+
+	page = b.Page(Query{After: gone, Limit: 2, Order: Ascending})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-7", "id-8"})
+
+	page = b.Page(Query{Before: gone, Limit: 2})
+	c.Assert(ids(page), check.DeepEquals, []string{"id-5", "id-4"})
+}
+
+func (s Suite) TestSequence(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		m := &Message{
+			ID:       fmt.Sprintf("id-%d", i),
+			Received: now.Add(time.Duration(i) * time.Minute),
+		}
+		b.Push(m)
+		c.Assert(m.Seq, check.Equals, uint64(i+1))
+	}
+
+	page := b.Page(Query{After: &Cursor{Seq: 7}, Limit: 100})
+	c.Assert(page.Messages, check.HasLen, 3)
+	c.Assert(page.Messages[2].Seq, check.Equals, uint64(8))
+	c.Assert(page.Dropped, check.Equals, uint64(0))
+
+	// Evict seq 1 through 4
+	b.Evict(now.Add(4 * time.Minute))
+
+	page = b.Page(Query{After: &Cursor{Seq: 2}, Limit: 100})
+	c.Assert(page.Messages, check.HasLen, 6)
+	c.Assert(page.Dropped, check.Equals, uint64(2))
+
+	page = b.Page(Query{After: &Cursor{Seq: 5}, Limit: 100})
+	c.Assert(page.Dropped, check.Equals, uint64(0))
+
+	// Sequence numbers are not reused
+	b.Purge()
+	m := &Message{ID: "id-10"}
+	b.Push(m)
+	c.Assert(m.Seq, check.Equals, uint64(11))
+
+	page = b.Page(Query{After: &Cursor{Seq: 8}, Limit: 100})
+	c.Assert(page.Messages, check.HasLen, 1)
+	c.Assert(page.Dropped, check.Equals, uint64(2))
+}
+
+func (s Suite) TestPageSeqCursorFromEitherEnd(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		b.Push(&Message{ID: fmt.Sprintf("id-%d", i), Received: now})
+	}
+	// Leave gaps in the sequence numbers. Every cursor below points at one of them.
+	for i := 0; i < 100; i += 3 {
+		b.Remove(fmt.Sprintf("id-%d", i))
+	}
+
+	ids := func(p *Page) []string {
+		out := []string{}
+		for _, m := range p.Messages {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		seq          uint64
+		after, older []string
+	}{
+		{1, []string{"id-1", "id-2"}, []string{}},
+		{4, []string{"id-4", "id-5"}, []string{"id-2", "id-1"}},
+		{10, []string{"id-10", "id-11"}, []string{"id-8", "id-7"}},
+		{91, []string{"id-91", "id-92"}, []string{"id-89", "id-88"}},
+		{100, []string{}, []string{"id-98", "id-97"}},
+		{200, []string{}, []string{"id-98", "id-97"}},
+	} {
+		page := b.Page(Query{After: &Cursor{ID: "gone", Seq: tc.seq}, Limit: 2, Order: Ascending})
+		c.Assert(ids(page), check.DeepEquals, tc.after)
+		page = b.Page(Query{Before: &Cursor{ID: "gone", Seq: tc.seq}, Limit: 2})
+		c.Assert(ids(page), check.DeepEquals, tc.older)
+	}
+}
+
 func (s Suite) TestEviction(c *check.C) {
 	b, err := s.registry.Create("a")
 	c.Assert(err, check.IsNil)
@@ -213,3 +602,104 @@ func (s Suite) TestEviction(c *check.C) {
 	c.Assert(b.Evict(now.Add(1*time.Minute)), check.Equals, 1)
 	c.Assert(b.Evict(now.Add(4*time.Minute)), check.Equals, 3)
 }
+
+func (s Suite) TestPinned(c *check.C) {
+	settings := DefaultSettings()
+	settings.SizeLimit = 2
+	Configure(settings)
+	defer Configure(DefaultSettings())
+
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	b.Push(&Message{ID: "pinned", Sender: "brett@buddin.us", Received: now, Pinned: true})
+	for i := 1; i <= 4; i++ {
+		b.Push(&Message{ID: fmt.Sprintf("id-%d", i), Sender: "brett@buddin.us", Received: now.Add(time.Duration(i) * time.Minute)})
+	}
+
+	var ids []string
+	for _, m := range b.Messages() {
+		ids = append(ids, m.ID)
+	}
+	c.Assert(ids, check.DeepEquals, []string{"pinned", "id-3", "id-4"})
+
+	c.Assert(b.Evict(now.Add(time.Hour)), check.Equals, 2)
+	c.Assert(b.Messages(), check.HasLen, 1)
+}
+
+func (s Suite) TestImport(c *check.C) {
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	b.Push(&Message{ID: "existing", Sender: "brett@buddin.us", Received: time.Now()})
+
+	old := time.Now().Add(-24 * time.Hour)
+	err = b.Import([]*Message{
+		{ID: "2", Sender: "brett@buddin.us", Received: old.Add(time.Minute)},
+		{ID: "1", Sender: "brett@buddin.us", Received: old},
+	})
+	c.Assert(err, check.IsNil)
+
+	var ids []string
+	var seqs []uint64
+	for _, m := range b.Messages() {
+		ids = append(ids, m.ID)
+		seqs = append(seqs, m.Seq)
+	}
+	c.Assert(ids, check.DeepEquals, []string{"existing", "1", "2"})
+	c.Assert(seqs, check.DeepEquals, []uint64{1, 2, 3})
+
+	// Imported messages expire relative to when they were imported.
+	c.Assert(b.Evict(time.Now().Add(-time.Hour)), check.Equals, 0)
+	c.Assert(b.Evict(time.Now().Add(time.Minute)), check.Equals, 3)
+
+	// Imports are all or nothing.
+	err = b.Import([]*Message{{ID: "3", Sender: "brett@buddin.us"}, {ID: "3", Sender: "brett@buddin.us"}})
+	c.Assert(err, check.ErrorMatches, "duplicate message: 3")
+	err = b.Import([]*Message{{ID: "4", Sender: "brett@buddin.us"}, {ID: "5", Body: strings.Repeat("x", 11<<20)}})
+	c.Assert(err, check.Equals, ErrMessageTooLarge)
+	c.Assert(len(b.Messages()), check.Equals, 0)
+}
+
+func (s Suite) TestImportFull(c *check.C) {
+	settings := DefaultSettings()
+	settings.SizeLimit = 2
+	Configure(settings)
+	defer Configure(DefaultSettings())
+
+	b, err := s.registry.Create("a")
+	c.Assert(err, check.IsNil)
+	b.Push(&Message{ID: "existing", Sender: "brett@buddin.us", Received: time.Now()})
+
+	// Nothing is dropped to make room.
+	err = b.Import([]*Message{{ID: "1", Sender: "brett@buddin.us"}, {ID: "2", Sender: "brett@buddin.us"}})
+	c.Assert(err, check.Equals, ErrMailboxFull)
+	c.Assert(len(b.Messages()), check.Equals, 1)
+
+	c.Assert(b.Import([]*Message{{ID: "1", Sender: "brett@buddin.us"}}), check.IsNil)
+	c.Assert(len(b.Messages()), check.Equals, 2)
+}
+
+func (s Suite) TestManualClock(c *check.C) {
+	clock := NewManualClock()
+	registry := NewRegistryWithClock(clock)
+	defer registry.Close()
+
+	at := time.Date(2016, 6, 30, 8, 17, 36, 0, time.UTC)
+	clock.Freeze()
+	clock.Set(at)
+	c.Assert(clock.Now(), check.Equals, at)
+	c.Assert(clock.Advance(time.Hour), check.Equals, at.Add(time.Hour))
+
+	clock.Resume()
+	c.Assert(clock.Frozen(), check.Equals, false)
+	c.Assert(clock.Now().Before(at.Add(time.Hour)), check.Equals, false)
+	c.Assert(clock.Now().Sub(at) < time.Hour+time.Minute, check.Equals, true)
+
+	b, err := registry.Create("a")
+	c.Assert(err, check.IsNil)
+	b.Import([]*Message{{ID: "1", Sender: "brett@buddin.us", Received: at}})
+	c.Assert(registry.Evict(), check.Equals, 0)
+	clock.Advance(CurrentSettings().ExpireAfter + time.Minute)
+	c.Assert(registry.Evict(), check.Equals, 1)
+}
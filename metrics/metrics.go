@@ -0,0 +1,330 @@
+// Package metrics collects counters, gauges and histograms and writes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the exposition format written by Set.Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector is a metric that can be written out.
+type Collector interface {
+	Name() string
+	Write(io.Writer) error
+}
+
+// Set is a collection of metrics.
+type Set struct {
+	sync.Mutex
+	collectors map[string]Collector
+}
+
+// NewSet creates an empty Set.
+func NewSet() *Set {
+	return &Set{collectors: map[string]Collector{}}
+}
+
+// Default is the Set metrics are registered with by the package-level constructors.
+var Default = NewSet()
+
+// Register adds collectors to the Set. Registering two collectors with the same name panics.
+func (s *Set) Register(collectors ...Collector) {
+	s.Lock()
+	defer s.Unlock()
+	for _, c := range collectors {
+		if _, ok := s.collectors[c.Name()]; ok {
+			panic(fmt.Sprintf("metrics: duplicate metric: %s", c.Name()))
+		}
+		s.collectors[c.Name()] = c
+	}
+}
+
+// Write writes every metric in the Set, ordered by name.
+func (s *Set) Write(w io.Writer) error {
+	s.Lock()
+	names := make([]string, 0, len(s.collectors))
+	for name := range s.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]Collector, len(names))
+	for i, name := range names {
+		collectors[i] = s.collectors[name]
+	}
+	s.Unlock()
+
+	for _, c := range collectors {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d desc) Name() string {
+	return d.name
+}
+
+func (d desc) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+	return err
+}
+
+// key joins label values into a map key.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labels[i], escapeLabel(v)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a value that only goes up, partitioned by labels.
+type Counter struct {
+	desc
+	sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates a Counter and registers it with Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: map[string]float64{}}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	Default.Register(c)
+	return c
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds a non-negative value to the counter.
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.name))
+	}
+	key := c.key(labels)
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += v
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value(labels ...string) float64 {
+	key := c.key(labels)
+	c.Lock()
+	defer c.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) Write(w io.Writer) error {
+	c.Lock()
+	defer c.Unlock()
+	return writeValues(w, c.desc, c.values)
+}
+
+// Gauge is a value that goes up and down, partitioned by labels.
+type Gauge struct {
+	desc
+	sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge creates a Gauge and registers it with Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge", labels}, values: map[string]float64{}}
+	if len(labels) == 0 {
+		g.values[""] = 0
+	}
+	Default.Register(g)
+	return g
+}
+
+// Set sets the gauge to a value.
+func (g *Gauge) Set(v float64, labels ...string) {
+	key := g.key(labels)
+	g.Lock()
+	defer g.Unlock()
+	g.values[key] = v
+}
+
+// Add adds a (possibly negative) value to the gauge.
+func (g *Gauge) Add(v float64, labels ...string) {
+	key := g.key(labels)
+	g.Lock()
+	defer g.Unlock()
+	g.values[key] += v
+}
+
+func (g *Gauge) Write(w io.Writer) error {
+	g.Lock()
+	defer g.Unlock()
+	return writeValues(w, g.desc, g.values)
+}
+
+// GaugeFunc is a gauge whose value is taken when it is written. It is not registered with any Set.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc creates a GaugeFunc.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return &GaugeFunc{desc{name, help, "gauge", nil}, fn}
+}
+
+func (g *GaugeFunc) Write(w io.Writer) error {
+	return writeValues(w, g.desc, map[string]float64{"": g.fn()})
+}
+
+// DefBuckets are the default upper bounds of Histogram buckets, tuned for request latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets, partitioned by labels.
+type Histogram struct {
+	desc
+	sync.Mutex
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a Histogram and registers it with Default. Buckets are the upper bounds of each bucket, in
+// increasing order.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	Default.Register(h)
+	return h
+}
+
+// Observe records a value.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.Lock()
+	defer h.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.Lock()
+	defer h.Unlock()
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hv := h.values[key]
+		for i, upper := range h.buckets {
+			le := strconv.FormatFloat(upper, 'g', -1, 64)
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", le), hv.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), hv.count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatValue(hv.sum)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), hv.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeValues(w io.Writer, d desc, values map[string]float64) error {
+	if err := d.writeHeader(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", d.name, d.labelPairs(key), formatValue(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
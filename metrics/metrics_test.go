@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/metrics"
+)
+
+func TestCounter(t *testing.T) {
+	c := metrics.NewCounter("test_counter_total", "A counter.", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("a\"b")
+
+	set := metrics.NewSet()
+	set.Register(c)
+
+	var buf bytes.Buffer
+	Equal(t, set.Write(&buf), nil)
+	Equal(t, buf.String(), `# HELP test_counter_total A counter.
+# TYPE test_counter_total counter
+test_counter_total{code="200"} 3
+test_counter_total{code="a\"b"} 1
+`)
+	Equal(t, c.Value("200"), float64(3))
+}
+
+func TestGauge(t *testing.T) {
+	g := metrics.NewGauge("test_gauge", "A gauge.")
+	g.Set(5)
+	g.Add(-2)
+
+	set := metrics.NewSet()
+	set.Register(g, metrics.NewGaugeFunc("test_gauge_func", "A gauge func.", func() float64 { return 7 }))
+
+	var buf bytes.Buffer
+	Equal(t, set.Write(&buf), nil)
+	Equal(t, buf.String(), `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 3
+# HELP test_gauge_func A gauge func.
+# TYPE test_gauge_func gauge
+test_gauge_func 7
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	h := metrics.NewHistogram("test_histogram", "A histogram.", []float64{1, 5}, "route")
+	h.Observe(0.5, "/")
+	h.Observe(3, "/")
+	h.Observe(10, "/")
+
+	set := metrics.NewSet()
+	set.Register(h)
+
+	var buf bytes.Buffer
+	Equal(t, set.Write(&buf), nil)
+	Equal(t, buf.String(), `# HELP test_histogram A histogram.
+# TYPE test_histogram histogram
+test_histogram_bucket{route="/",le="1"} 1
+test_histogram_bucket{route="/",le="5"} 2
+test_histogram_bucket{route="/",le="+Inf"} 3
+test_histogram_sum{route="/"} 13.5
+test_histogram_count{route="/"} 3
+`)
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	set := metrics.NewSet()
+	set.Register(metrics.NewGaugeFunc("test_duplicate", "", func() float64 { return 0 }))
+	PanicMatches(t, func() {
+		set.Register(metrics.NewGaugeFunc("test_duplicate", "", func() float64 { return 0 }))
+	}, "metrics: duplicate metric: test_duplicate")
+}
@@ -0,0 +1,133 @@
+// Package ponyexpresstest runs ponyexpress inside Go tests.
+//
+//	func TestSignup(t *testing.T) {
+//		px := ponyexpresstest.New(t)
+//		address := px.Mailbox(t)
+//		signup(address) // the code under test sends mail to address
+//		msg := px.RequireMessage(t, address, 5*time.Second, ponyexpresstest.SubjectContains("Welcome"))
+//	}
+//
+// Everything started by New is stopped when the test finishes, and the settings it changed are restored.
+package ponyexpresstest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress"
+	"github.com/brettbuddin/ponyexpress/client"
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+)
+
+// Server is a ponyexpress instance listening on a random local port.
+type Server struct {
+	// URL is the base URL of the HTTP API, e.g. "http://127.0.0.1:51234".
+	URL string
+
+	// HTTPAddr is the address the HTTP API listens on.
+	HTTPAddr string
+
+	// Client talks to the HTTP API.
+	Client *client.Client
+
+	// Registry holds the mailboxes, for tests that want to reach past the API.
+	Registry *mailbox.Registry
+
+	// Clock is the time as far as the Registry is concerned. Freezing or advancing it, followed by Registry.Evict,
+	// expires messages without waiting. The /admin/clock endpoints control it too.
+	Clock *mailbox.ManualClock
+}
+
+// New starts a Server and stops it when the test finishes. Functions given to change the configuration are applied
+// over the defaults; since retention and limit settings are shared by every Registry, tests that change them must not
+// run in parallel. The logging settings are left alone, except that the http subsystem logs at warn unless it already
+// has a level of its own, so that requests aren't logged.
+func New(t testing.TB, configure ...func(*config.Config)) *Server {
+	t.Helper()
+
+	settings, logging := mailbox.CurrentSettings(), logger.Current()
+	t.Cleanup(func() {
+		mailbox.Configure(settings)
+		logger.Configure(logging)
+	})
+
+	if len(configure) > 0 {
+		cfg := config.Default()
+		for _, f := range configure {
+			f(&cfg)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("ponyexpresstest: %s", err)
+		}
+		mailbox.Configure(cfg.MailboxSettings())
+	}
+
+	quiet := logger.Current()
+	if _, ok := quiet.Subsystems["http"]; !ok {
+		quiet.Subsystems["http"] = logger.WarnLevel
+		logger.Configure(quiet)
+	}
+
+	clock := mailbox.NewManualClock()
+	registry := mailbox.NewRegistryWithClock(clock)
+	ctx := context.WithValue(context.Background(), "registry", registry)
+	ctx = context.WithValue(ctx, "clock", clock)
+	ts := httptest.NewServer(ponyexpress.New(ctx))
+	t.Cleanup(func() {
+		ts.Close()
+		registry.Close()
+	})
+
+	c := client.New(ts.URL)
+	c.PollInterval = 20 * time.Millisecond
+	return &Server{
+		URL:      ts.URL,
+		HTTPAddr: ts.Listener.Addr().String(),
+		Client:   c,
+		Registry: registry,
+		Clock:    clock,
+	}
+}
+
+// Mailbox creates a mailbox and returns its address.
+func (s *Server) Mailbox(t testing.TB) string {
+	t.Helper()
+	box, err := s.Client.CreateMailbox(context.Background())
+	if err != nil {
+		t.Fatalf("ponyexpresstest: creating mailbox: %s", err)
+	}
+	return box.ID
+}
+
+// RequireMessage waits for a message matched by match to arrive in a mailbox and returns it. A nil match accepts any
+// message. The test fails if none arrives within timeout.
+func (s *Server) RequireMessage(t testing.TB, address string, timeout time.Duration, match func(*mailbox.Message) bool) *mailbox.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	msg, err := s.Client.Wait(ctx, address, "", match)
+	if err == context.DeadlineExceeded {
+		t.Fatalf("ponyexpresstest: no matching message arrived in %s within %s", address, timeout)
+	}
+	if err != nil {
+		t.Fatalf("ponyexpresstest: waiting for a message in %s: %s", address, err)
+	}
+	return msg
+}
+
+// SubjectContains matches messages whose subject contains s.
+func SubjectContains(s string) func(*mailbox.Message) bool {
+	return func(m *mailbox.Message) bool { return strings.Contains(m.Subject, s) }
+}
+
+// From matches messages sent by sender.
+func From(sender string) func(*mailbox.Message) bool {
+	return func(m *mailbox.Message) bool { return m.Sender == sender }
+}
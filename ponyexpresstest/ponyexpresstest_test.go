@@ -0,0 +1,75 @@
+package ponyexpresstest_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/config"
+	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/mailbox"
+	"github.com/brettbuddin/ponyexpress/ponyexpresstest"
+)
+
+func TestRequireMessage(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	address := px.Mailbox(t)
+
+	box, err := px.Registry.Get(address)
+	Equal(t, err, nil)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		box.Push(&mailbox.Message{ID: "1", Sender: "a@b.c", Subject: "Welcome aboard", Body: "hi", Received: time.Now()})
+	}()
+
+	msg := px.RequireMessage(t, address, 5*time.Second, ponyexpresstest.SubjectContains("Welcome"))
+	Equal(t, msg.ID, "1")
+	Equal(t, ponyexpresstest.From("a@b.c")(msg), true)
+
+	resp, err := http.Get(px.URL + "/healthz")
+	Equal(t, err, nil)
+	Equal(t, resp.StatusCode, http.StatusOK)
+	NotEqual(t, px.HTTPAddr, "")
+}
+
+func TestConfigure(t *testing.T) {
+	var out bytes.Buffer
+	previous := logger.Current()
+	defer logger.Configure(previous)
+	logger.Configure(logger.Config{Output: &out, Format: logger.JSONFormat, Level: logger.InfoLevel})
+
+	t.Run("configured", func(t *testing.T) {
+		px := ponyexpresstest.New(t, func(cfg *config.Config) {
+			cfg.Limits.MaxMessageSize = 1024
+		})
+		Equal(t, mailbox.CurrentSettings().MaxMessageSize, 1024)
+
+		_, err := http.Get(px.URL + "/mailboxes")
+		Equal(t, err, nil)
+		Equal(t, out.Len(), 0)
+	})
+	Equal(t, mailbox.CurrentSettings().MaxMessageSize, mailbox.DefaultSettings().MaxMessageSize)
+
+	// The caller's logging configuration is back in effect.
+	restored := logger.Current()
+	Equal(t, restored.Output, &out)
+	Equal(t, restored.Format, logger.JSONFormat)
+	Equal(t, len(restored.Subsystems), 0)
+}
+
+func TestClock(t *testing.T) {
+	px := ponyexpresstest.New(t)
+	address := px.Mailbox(t)
+	box, err := px.Registry.Get(address)
+	Equal(t, err, nil)
+
+	px.Clock.Freeze()
+	box.Push(&mailbox.Message{ID: "1", Sender: "a@b.c", Received: px.Clock.Now()})
+	Equal(t, px.Registry.Evict(), 0)
+
+	px.Clock.Advance(mailbox.CurrentSettings().ExpireAfter + time.Second)
+	Equal(t, px.Registry.Evict(), 1)
+}
@@ -0,0 +1,147 @@
+// Package ratelimit implements token buckets: each caller may make up to Burst requests at once, and regains Rate
+// requests per second afterwards.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Result describes the state of a bucket after taking a token from it.
+type Result struct {
+	Allowed bool
+
+	// Limit is the size of the bucket.
+	Limit int
+
+	// Remaining is the number of whole tokens left.
+	Remaining int
+
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+
+	// RetryAfter is how long until a token is available. It's zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+// SetHeaders describes the result in the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, plus
+// Retry-After when the request was refused. Durations are rounded up to whole seconds.
+func (r Result) SetHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", seconds(r.Reset))
+	if !r.Allowed {
+		h.Set("Retry-After", seconds(r.RetryAfter))
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Bucket is a single token bucket. The zero value is a full bucket. A Bucket must not be used concurrently.
+type Bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Take takes a token from the bucket at time now if one is available. The bucket holds up to burst tokens and refills
+// at rate tokens per second.
+func (b *Bucket) Take(now time.Time, rate float64, burst int) Result {
+	b.refill(now, rate, burst)
+
+	r := Result{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = duration((1 - b.tokens) / rate)
+	}
+	r.Remaining = int(b.tokens)
+	r.Reset = duration((float64(burst) - b.tokens) / rate)
+	return r
+}
+
+// full reports whether the bucket would be full at time now.
+func (b *Bucket) full(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	return b.tokens >= float64(burst)
+}
+
+func (b *Bucket) refill(now time.Time, rate float64, burst int) {
+	if b.updated.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	}
+	b.updated = now
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// pruneEvery is how often a Limiter forgets callers whose buckets have refilled.
+const pruneEvery = time.Minute
+
+// Limiter keeps a Bucket for each caller, named by a key such as an IP address. It's safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*Bucket
+	pruned  time.Time
+}
+
+// New creates a Limiter allowing each caller burst requests at once and rate requests per second after that.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: burst, buckets: map[string]*Bucket{}}
+}
+
+// SetLimits changes the limits for every caller. Buckets keep their tokens, up to the new burst. A rate of zero lifts
+// the limit.
+func (l *Limiter) SetLimits(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, burst
+}
+
+// Enabled reports whether requests are limited.
+func (l *Limiter) Enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// Allow takes a token from the caller's bucket.
+func (l *Limiter) Allow(key string) Result {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt takes a token from the caller's bucket at a point in time.
+func (l *Limiter) AllowAt(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return Result{Allowed: true}
+	}
+	if now.Sub(l.pruned) >= pruneEvery {
+		for k, b := range l.buckets {
+			if b.full(now, l.rate, l.burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &Bucket{}
+		l.buckets[key] = b
+	}
+	return b.Take(now, l.rate, l.burst)
+}
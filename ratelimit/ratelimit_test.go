@@ -0,0 +1,77 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/ratelimit"
+)
+
+func TestBucket(t *testing.T) {
+	var b ratelimit.Bucket
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		r := b.Take(now, 1, 3)
+		Equal(t, r.Allowed, true)
+		Equal(t, r.Limit, 3)
+		Equal(t, r.Remaining, i)
+	}
+
+	r := b.Take(now, 1, 3)
+	Equal(t, r.Allowed, false)
+	Equal(t, r.RetryAfter, time.Second)
+	Equal(t, r.Reset, 3*time.Second)
+
+	r = b.Take(now.Add(1500*time.Millisecond), 1, 3)
+	Equal(t, r.Allowed, true)
+	Equal(t, r.Remaining, 0)
+
+	// The bucket never holds more than the burst.
+	r = b.Take(now.Add(time.Hour), 1, 3)
+	Equal(t, r.Remaining, 2)
+}
+
+func TestLimiter(t *testing.T) {
+	l := ratelimit.New(0.5, 1)
+	now := time.Now()
+
+	Equal(t, l.AllowAt("a", now).Allowed, true)
+	Equal(t, l.AllowAt("a", now).Allowed, false)
+	Equal(t, l.AllowAt("b", now).Allowed, true)
+	Equal(t, l.AllowAt("a", now.Add(2*time.Second)).Allowed, true)
+
+	// Buckets pruned once they are full start over full.
+	Equal(t, l.AllowAt("a", now.Add(time.Hour)).Allowed, true)
+}
+
+func TestSetLimits(t *testing.T) {
+	l := ratelimit.New(0.5, 1)
+	now := time.Now()
+	Equal(t, l.AllowAt("a", now).Allowed, true)
+	Equal(t, l.AllowAt("a", now).Allowed, false)
+
+	// Raising the limits refills buckets at the new rate.
+	l.SetLimits(1, 3)
+	r := l.AllowAt("a", now.Add(time.Second))
+	Equal(t, r.Allowed, true)
+	Equal(t, r.Limit, 3)
+
+	l.SetLimits(0, 0)
+	Equal(t, l.Enabled(), false)
+	for i := 0; i < 10; i++ {
+		Equal(t, l.AllowAt("a", now).Allowed, true)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	ratelimit.Result{Allowed: false, Limit: 10, Remaining: 0, Reset: 2500 * time.Millisecond, RetryAfter: 200 * time.Millisecond}.SetHeaders(h)
+	Equal(t, h.Get("RateLimit-Limit"), "10")
+	Equal(t, h.Get("RateLimit-Remaining"), "0")
+	Equal(t, h.Get("RateLimit-Reset"), "3")
+	Equal(t, h.Get("Retry-After"), "1")
+}
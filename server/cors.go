@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// CORSOptions configures the CORS filter.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests. "*" allows any origin.
+	AllowedOrigins []string
+
+	// AllowedMethods lists the methods allowed in cross-origin requests. It defaults to every method but CONNECT and
+	// TRACE.
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed in cross-origin requests. "*" allows any header. It defaults to
+	// Content-Type.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers, beyond the CORS-safelisted ones, that browsers may read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests to carry cookies and client certificates.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache the result of a preflight request. Zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+var (
+	defaultCORSMethods = []string{MethodDELETE, MethodGET, MethodHEAD, MethodPATCH, MethodPOST, MethodPUT}
+	defaultCORSHeaders = []string{"Content-Type"}
+)
+
+// CORS answers cross-origin requests from the allowed origins. Preflight requests to registered routes are answered by
+// the filter without reaching the route's handlers.
+func CORS(opts CORSOptions) Filter {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = defaultCORSHeaders
+	}
+
+	return func(next ContextHandle) ContextHandle {
+		return func(c context.Context, w ResponseWriter, r *Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || !opts.allowsOrigin(origin) {
+				next(c, w, r)
+				return
+			}
+
+			_, routed := c.Value(ContextRoute).(string)
+			method := r.Header.Get("Access-Control-Request-Method")
+			if r.Method == MethodOPTIONS && method != "" && routed {
+				opts.preflight(w, r, origin, method)
+				return
+			}
+
+			opts.setOrigin(w, origin)
+			if len(opts.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+			}
+			next(c, w, r)
+		}
+	}
+}
+
+func (o CORSOptions) preflight(w ResponseWriter, r *Request, origin, method string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	var headers []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+	if !contains(o.AllowedMethods, method) || !o.allowsHeaders(headers) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	o.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if o.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o CORSOptions) setOrigin(w ResponseWriter, origin string) {
+	if contains(o.AllowedOrigins, "*") && !o.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if o.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (o CORSOptions) allowsOrigin(origin string) bool {
+	return contains(o.AllowedOrigins, "*") || contains(o.AllowedOrigins, origin)
+}
+
+func (o CORSOptions) allowsHeaders(headers []string) bool {
+	if contains(o.AllowedHeaders, "*") {
+		return true
+	}
+	for _, h := range headers {
+		if !contains(o.AllowedHeaders, h) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports whether list holds s, ignoring case.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
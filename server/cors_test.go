@@ -0,0 +1,117 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+func corsServer(opts server.CORSOptions) *httptest.Server {
+	app := server.New(context.Background())
+	app.AddFilters(server.CORS(opts))
+	handler := func(c context.Context, w server.ResponseWriter, r *server.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	app.GET("/things/:id", handler)
+	app.DELETE("/things/:id", handler)
+	app.POST("/things/batch", handler)
+	return httptest.NewServer(app)
+}
+
+func request(t *testing.T, method, url string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	Equal(t, err, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	Equal(t, err, nil)
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORSPreflight(t *testing.T) {
+	ts := corsServer(server.CORSOptions{
+		AllowedOrigins: []string{"http://example.com"},
+		AllowedHeaders: []string{"Content-Type", "X-Trace"},
+		MaxAge:         10 * time.Minute,
+	})
+	defer ts.Close()
+
+	resp := request(t, "OPTIONS", ts.URL+"/things/1", map[string]string{
+		"Origin":                         "http://example.com",
+		"Access-Control-Request-Method":  "DELETE",
+		"Access-Control-Request-Headers": "x-trace",
+	})
+	Equal(t, resp.StatusCode, http.StatusNoContent)
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "http://example.com")
+	Equal(t, resp.Header.Get("Access-Control-Allow-Methods"), "DELETE, GET, HEAD, PATCH, POST, PUT")
+	Equal(t, resp.Header.Get("Access-Control-Allow-Headers"), "x-trace")
+	Equal(t, resp.Header.Get("Access-Control-Max-Age"), "600")
+
+	resp = request(t, "OPTIONS", ts.URL+"/things/batch", map[string]string{
+		"Origin":                        "http://example.com",
+		"Access-Control-Request-Method": "POST",
+	})
+	Equal(t, resp.StatusCode, http.StatusNoContent)
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "http://example.com")
+
+	// Disallowed origins and headers get no CORS headers.
+	resp = request(t, "OPTIONS", ts.URL+"/things/1", map[string]string{
+		"Origin":                        "http://evil.com",
+		"Access-Control-Request-Method": "GET",
+	})
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "")
+	resp = request(t, "OPTIONS", ts.URL+"/things/1", map[string]string{
+		"Origin":                         "http://example.com",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "Authorization",
+	})
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "")
+
+	// Preflights to unknown routes aren't answered.
+	resp = request(t, "OPTIONS", ts.URL+"/nothing", map[string]string{
+		"Origin":                        "http://example.com",
+		"Access-Control-Request-Method": "GET",
+	})
+	Equal(t, resp.StatusCode, http.StatusNotFound)
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "")
+}
+
+func TestCORSRequest(t *testing.T) {
+	ts := corsServer(server.CORSOptions{
+		AllowedOrigins:   []string{"*"},
+		ExposedHeaders:   []string{"Request-Id"},
+		AllowCredentials: true,
+	})
+	defer ts.Close()
+
+	resp := request(t, "GET", ts.URL+"/things/1", map[string]string{"Origin": "http://example.com"})
+	Equal(t, resp.StatusCode, http.StatusOK)
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "http://example.com")
+	Equal(t, resp.Header.Get("Access-Control-Allow-Credentials"), "true")
+	Equal(t, resp.Header.Get("Access-Control-Expose-Headers"), "Request-Id")
+	Equal(t, resp.Header.Get("Vary"), "Origin")
+
+	resp = request(t, "GET", ts.URL+"/things/1", nil)
+	Equal(t, resp.Header.Get("Access-Control-Allow-Origin"), "")
+}
+
+func TestAutomaticOptions(t *testing.T) {
+	ts := corsServer(server.CORSOptions{})
+	defer ts.Close()
+
+	resp := request(t, "OPTIONS", ts.URL+"/things/1", nil)
+	Equal(t, resp.StatusCode, http.StatusNoContent)
+	Equal(t, resp.Header.Get("Allow"), "DELETE, GET, OPTIONS")
+	NotEqual(t, resp.Header.Get("Request-Id"), "")
+
+	resp = request(t, "OPTIONS", ts.URL+"/things/batch", nil)
+	Equal(t, resp.Header.Get("Allow"), "OPTIONS, POST")
+}
@@ -2,17 +2,23 @@ package server
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/nu7hatch/gouuid"
 	"golang.org/x/net/context"
 
 	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/metrics"
 )
 
 // Keys set in the Context
 const (
-	ContextRequestID = "request_id"
+	ContextRequestID      = "request_id"
+	ContextRoute          = "route"
+	ContextClientIdentity = "client_identity"
+	ContextQuiet          = "quiet"
 
 	requestIDHeader = "Request-Id"
 	runtimeHeader   = "Runtime"
@@ -48,29 +54,91 @@ func setRequestIDHeader(next ContextHandle) ContextHandle {
 			panic(err)
 		}
 		w.Header().Set(requestIDHeader, id.String())
-		next(context.WithValue(c, ContextRequestID, id.String()), w, r)
+		c = context.WithValue(c, ContextRequestID, id.String())
+		c = logger.NewContext(c, logger.FromContext(c, "http").With(logger.Fields{"request_id": id.String()}))
+		next(c, w, r)
 	}
 }
 
+// setClientIdentity identifies callers that presented a verified TLS client certificate by the certificate's subject
+// common name.
+func setClientIdentity(next ContextHandle) ContextHandle {
+	return func(c context.Context, w ResponseWriter, r *Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			identity := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			c = context.WithValue(c, ContextClientIdentity, identity)
+			c = logger.NewContext(c, logger.FromContext(c, "http").With(logger.Fields{"client": identity}))
+		}
+		next(c, w, r)
+	}
+}
+
+var (
+	requestsTotal = metrics.NewCounter(
+		"ponyexpress_http_requests_total",
+		"Number of HTTP requests answered.",
+		"method", "route", "status",
+	)
+	requestDuration = metrics.NewHistogram(
+		"ponyexpress_http_request_duration_seconds",
+		"Time taken to answer HTTP requests.",
+		metrics.DefBuckets,
+		"method", "route", "status",
+	)
+)
+
+// unmatchedRoute labels metrics for requests that didn't match a registered route.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels metrics for requests with a method outside knownMethods, so that callers can't create label
+// values at will.
+const otherMethod = "other"
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
 func setRuntimeHeader(next ContextHandle) ContextHandle {
 	return func(c context.Context, w ResponseWriter, r *Request) {
 		var start time.Time
-		requestID := c.Value(ContextRequestID)
+		log := logger.FromContext(c, "http")
+		route, ok := c.Value(ContextRoute).(string)
+		if !ok {
+			route = unmatchedRoute
+		}
+		method := r.Method
+		if !knownMethods[method] {
+			method = otherMethod
+		}
+
+		finished := log.Info
+		if quiet, _ := c.Value(ContextQuiet).(bool); quiet {
+			finished = log.Debug
+		}
 
 		w.BeforeWrite(func(w ResponseWriter) {
 			elapsed := time.Since(start).Seconds()
+			status := strconv.Itoa(w.Status())
+			requestsTotal.Inc(method, route, status)
+			requestDuration.Observe(elapsed, method, route, status)
 			w.Header().Set(runtimeHeader, fmt.Sprintf("%f", elapsed))
-			logger.Infof("finished: %s", logger.Fields{
-				"request_id": requestID,
-				"method":     r.Method,
-				"uri":        r.RequestURI,
-				"status":     w.Status(),
-				"elapsed":    fmt.Sprintf("%f", elapsed),
+			finished("finished", logger.Fields{
+				"method":  r.Method,
+				"uri":     r.RequestURI,
+				"status":  w.Status(),
+				"elapsed": fmt.Sprintf("%f", elapsed),
 			})
 		})
 
-		logger.Debugf("started: %s", logger.Fields{
-			"id":     requestID,
+		log.Debug("started", logger.Fields{
 			"method": r.Method,
 			"uri":    r.RequestURI,
 		})
@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -8,6 +9,8 @@ import (
 	"golang.org/x/net/context"
 	. "gopkg.in/go-playground/assert.v1"
 
+	"github.com/brettbuddin/ponyexpress/logger"
+	"github.com/brettbuddin/ponyexpress/ratelimit"
 	"github.com/brettbuddin/ponyexpress/server"
 )
 
@@ -72,3 +75,65 @@ func TestSetRequestHeaderFilter(t *testing.T) {
 	Equal(t, err, nil)
 	Equal(t, resp.StatusCode, 200)
 }
+
+func TestQuietRoutes(t *testing.T) {
+	var buf bytes.Buffer
+	logger.Configure(logger.Config{Output: &buf, Level: logger.InfoLevel})
+	defer logger.Configure(logger.Config{Level: logger.InfoLevel})
+
+	app := server.New(context.Background())
+	app.Quiet("/healthz")
+	handler := func(c context.Context, w server.ResponseWriter, r *server.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	app.GET("/healthz", handler)
+	app.GET("/", handler)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	_, err := http.Get(server.URL + "/healthz")
+	Equal(t, err, nil)
+	Equal(t, buf.String(), "")
+
+	_, err = http.Get(server.URL)
+	Equal(t, err, nil)
+	MatchRegex(t, buf.String(), `msg=finished .*uri=/\n`)
+}
+
+func TestRateLimit(t *testing.T) {
+	app := server.New(context.Background())
+	limiter := ratelimit.New(0.001, 2)
+	app.AddFilters(server.RateLimit(limiter, nil))
+	app.Quiet("/healthz")
+	handler := func(c context.Context, w server.ResponseWriter, r *server.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	app.GET("/", handler)
+	app.GET("/healthz", handler)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	for _, remaining := range []string{"1", "0"} {
+		resp, err := http.Get(server.URL)
+		Equal(t, err, nil)
+		Equal(t, resp.StatusCode, http.StatusOK)
+		Equal(t, resp.Header.Get("RateLimit-Limit"), "2")
+		Equal(t, resp.Header.Get("RateLimit-Remaining"), remaining)
+	}
+
+	resp, err := http.Get(server.URL)
+	Equal(t, err, nil)
+	Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+	Equal(t, resp.Header.Get("Retry-After"), "1000")
+
+	resp, err = http.Get(server.URL + "/healthz")
+	Equal(t, err, nil)
+	Equal(t, resp.StatusCode, http.StatusOK)
+
+	// Lifting the limit takes effect immediately.
+	limiter.SetLimits(0, 0)
+	resp, err = http.Get(server.URL)
+	Equal(t, err, nil)
+	Equal(t, resp.StatusCode, http.StatusOK)
+	Equal(t, resp.Header.Get("RateLimit-Limit"), "")
+}
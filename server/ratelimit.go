@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/ratelimit"
+)
+
+// RateLimit limits how often each caller may make requests. Callers are identified by their client certificate
+// (ContextClientIdentity) or, failing that, their IP address. Every response carries RateLimit-* headers; refused
+// requests are answered by limited, with a Retry-After header. Quiet routes such as health checks are exempt, and
+// nothing is limited while the Limiter is disabled.
+func RateLimit(l *ratelimit.Limiter, limited ContextHandle) Filter {
+	if limited == nil {
+		limited = func(c context.Context, w ResponseWriter, r *Request) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	}
+
+	return func(next ContextHandle) ContextHandle {
+		return func(c context.Context, w ResponseWriter, r *Request) {
+			if quiet, _ := c.Value(ContextQuiet).(bool); quiet || !l.Enabled() {
+				next(c, w, r)
+				return
+			}
+
+			result := l.Allow(callerKey(c, r))
+			result.SetHeaders(w.Header())
+			if !result.Allowed {
+				limited(c, w, r)
+				return
+			}
+			next(c, w, r)
+		}
+	}
+}
+
+func callerKey(c context.Context, r *Request) string {
+	if identity, ok := c.Value(ContextClientIdentity).(string); ok {
+		return "client:" + identity
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/julienschmidt/httprouter"
@@ -15,6 +17,7 @@ func New(ctx context.Context) *Server {
 		context: ctx,
 		filters: []Filter{
 			setRequestIDHeader,
+			setClientIdentity,
 			setRuntimeHeader,
 		},
 	}
@@ -25,6 +28,9 @@ type Server struct {
 	router  *httprouter.Router
 	context context.Context
 	filters []Filter
+	quiet   map[string]bool
+	methods map[string][]string
+	options map[string]httprouter.Handle
 	once    sync.Once
 
 	NotFoundHandler ContextHandle
@@ -37,6 +43,17 @@ func (r *Server) AddFilters(filters ...Filter) {
 	r.filters = append(r.filters, filters...)
 }
 
+// Quiet lowers the log level of requests to paths, such as health checks, that are polled often enough to drown out
+// everything else. It must be called before handlers are registered at those paths.
+func (r *Server) Quiet(paths ...string) {
+	if r.quiet == nil {
+		r.quiet = map[string]bool{}
+	}
+	for _, path := range paths {
+		r.quiet[path] = true
+	}
+}
+
 // HTTP methods.
 const (
 	MethodDELETE  = "DELETE"
@@ -85,6 +102,10 @@ func (r *Server) PUT(path string, h ContextHandle) {
 
 // Handle registers a handler for a particular HTTP method at a path.
 func (r *Server) Handle(method, path string, h ContextHandle) {
+	if r.methods == nil {
+		r.methods = map[string][]string{}
+	}
+	r.methods[path] = append(r.methods[path], method)
 	r.handle(method, path, r.applyFilters(h))
 }
 
@@ -100,18 +121,95 @@ func (r *Server) applyFilters(h ContextHandle) ContextHandle {
 }
 
 func (r *Server) handle(method, path string, h ContextHandle) {
-	r.router.Handle(method, path, func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
-		h(r.context, NewResponseWriter(w), &Request{req, p})
-	})
+	r.router.Handle(method, path, r.bind(path, h))
+}
+
+// bind adapts a handler registered at a path to the router.
+func (r *Server) bind(path string, h ContextHandle) httprouter.Handle {
+	ctx := context.WithValue(r.context, ContextRoute, path)
+	if r.quiet[path] {
+		ctx = context.WithValue(ctx, ContextQuiet, true)
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		h(ctx, NewResponseWriter(w), &Request{req, p})
+	}
 }
 
 // ServeHTTP is the entry-point for HTTP routing. It conforms to `http.Handler` interface. The first time this method is
-// called (by an inbound request) error handlers are registered with the underlying HTTP router.
+// called (by an inbound request) error handlers are registered with the underlying HTTP router and OPTIONS handlers
+// are prepared.
 func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.once.Do(r.registerErrorHandlers)
+	r.once.Do(func() {
+		r.registerErrorHandlers()
+		r.registerOptionsHandlers()
+	})
+	if req.Method == MethodOPTIONS {
+		if h, _, _ := r.router.Lookup(MethodOPTIONS, req.URL.Path); h == nil {
+			if path, ok := r.match(req.URL.Path); ok {
+				r.options[path](w, req, nil)
+				return
+			}
+		}
+	}
 	r.router.ServeHTTP(w, req)
 }
 
+// registerOptionsHandlers prepares answers to OPTIONS requests for every path without an OPTIONS handler of its own.
+// Unlike the router's automatic replies, these pass through the filters, which lets filters such as CORS answer
+// preflight requests. They're kept out of the router because its OPTIONS tree couldn't hold both a static segment and
+// a wildcard at the same position (e.g. "messages/batch-delete" and "messages/:message_id").
+func (r *Server) registerOptionsHandlers() {
+	r.options = map[string]httprouter.Handle{}
+	for path, methods := range r.methods {
+		if contains(methods, MethodOPTIONS) {
+			continue
+		}
+		allow := append(append([]string{}, methods...), MethodOPTIONS)
+		sort.Strings(allow)
+		r.options[path] = r.bind(path, r.applyFilters(func(c context.Context, w ResponseWriter, req *Request) {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+}
+
+// match finds the registered path that a request path would be routed to. Static segments take precedence over
+// parameters, as they do in the router.
+func (r *Server) match(reqPath string) (string, bool) {
+	segments := strings.Split(reqPath, "/")
+	best, bestStatic := "", -1
+	for path := range r.options {
+		static, ok := matchSegments(strings.Split(path, "/"), segments)
+		if ok && static > bestStatic {
+			best, bestStatic = path, static
+		}
+	}
+	return best, bestStatic >= 0
+}
+
+// matchSegments reports whether request path segments match a registered path's and, if so, how many of them matched
+// static segments.
+func matchSegments(pattern, segments []string) (int, bool) {
+	static := 0
+	for i, p := range pattern {
+		switch {
+		case strings.HasPrefix(p, "*"):
+			return static, true
+		case i >= len(segments):
+			return 0, false
+		case strings.HasPrefix(p, ":"):
+			if segments[i] == "" {
+				return 0, false
+			}
+		case p == segments[i]:
+			static++
+		default:
+			return 0, false
+		}
+	}
+	return static, len(pattern) == len(segments)
+}
+
 func (r *Server) registerErrorHandlers() {
 	if r.NotFoundHandler != nil {
 		r.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
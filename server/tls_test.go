@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := server.SelfSignedCertificate("localhost", "127.0.0.1")
+	Equal(t, err, nil)
+	Equal(t, cert.Leaf.DNSNames, []string{"localhost"})
+	Equal(t, len(cert.Leaf.IPAddresses), 1)
+	Equal(t, cert.Leaf.VerifyHostname("localhost"), nil)
+	Equal(t, cert.Leaf.VerifyHostname("127.0.0.1"), nil)
+}
+
+func clientCertificate(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Equal(t, err, nil)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	Equal(t, err, nil)
+	leaf, err := x509.ParseCertificate(der)
+	Equal(t, err, nil)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientIdentity(t *testing.T) {
+	serverCert, err := server.SelfSignedCertificate("127.0.0.1")
+	Equal(t, err, nil)
+	clientCert := clientCertificate(t, "ci-runner")
+
+	app := server.New(context.Background())
+	app.GET("/", func(c context.Context, w server.ResponseWriter, r *server.Request) {
+		identity, _ := c.Value(server.ContextClientIdentity).(string)
+		w.Write([]byte(identity))
+	})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert.Leaf)
+	ts := httptest.NewUnstartedServer(app)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(serverCert.Leaf)
+	get := func(certs ...tls.Certificate) string {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: certs,
+		}}}
+		resp, err := client.Get(ts.URL)
+		Equal(t, err, nil)
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n])
+	}
+
+	Equal(t, get(clientCert), "ci-runner")
+	Equal(t, get(), "")
+}
@@ -0,0 +1,294 @@
+// ponyexpress web interface. Everything here goes through the public HTTP API.
+(function () {
+  "use strict";
+
+  var POLL_INTERVAL = 2000;
+  var PAGE_SIZE = 100;
+  var STORAGE_KEY = "ponyexpress.mailboxes";
+
+  var state = {
+    mailboxes: JSON.parse(localStorage.getItem(STORAGE_KEY) || "[]"),
+    mailbox: null,
+    messages: [],
+    message: null,
+    cursor: null,
+    view: "html",
+    timer: null
+  };
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function api(method, path, body) {
+    var opts = { method: method, headers: {} };
+    if (body !== undefined) {
+      opts.headers["Content-Type"] = "application/json";
+      opts.body = JSON.stringify(body);
+    }
+    return fetch(path, opts).then(function (resp) {
+      if (resp.status === 204) {
+        return null;
+      }
+      return resp.json().then(function (content) {
+        if (!resp.ok) {
+          throw new Error((content && content.error) || resp.statusText);
+        }
+        return content;
+      });
+    });
+  }
+
+  function status(text) {
+    $("status").textContent = text;
+  }
+
+  function messagesPath(address) {
+    return "/mailboxes/" + encodeURIComponent(address) + "/messages";
+  }
+
+  // Mailboxes
+
+  function saveMailboxes() {
+    localStorage.setItem(STORAGE_KEY, JSON.stringify(state.mailboxes));
+  }
+
+  function rememberMailbox(address) {
+    if (state.mailboxes.indexOf(address) < 0) {
+      state.mailboxes.push(address);
+      saveMailboxes();
+    }
+  }
+
+  function forgetMailbox(address) {
+    state.mailboxes = state.mailboxes.filter(function (a) { return a !== address; });
+    saveMailboxes();
+  }
+
+  function renderMailboxes() {
+    var list = $("mailbox-list");
+    list.textContent = "";
+    state.mailboxes.forEach(function (address) {
+      var li = document.createElement("li");
+      li.textContent = address;
+      li.title = address;
+      li.classList.toggle("selected", address === state.mailbox);
+      li.addEventListener("click", function () { openMailbox(address); });
+      list.appendChild(li);
+    });
+  }
+
+  function openMailbox(address) {
+    clearTimeout(state.timer);
+    state.mailbox = address;
+    state.messages = [];
+    state.message = null;
+    state.cursor = null;
+    $("mailbox-title").textContent = address;
+    $("delete-mailbox").hidden = false;
+    $("message").hidden = true;
+    renderMailboxes();
+    renderMessages();
+
+    api("GET", messagesPath(address) + "?order=desc&limit=" + PAGE_SIZE).then(function (content) {
+      rememberMailbox(address);
+      renderMailboxes();
+      state.messages = content.messages;
+      state.cursor = content.meta.prev_cursor || null;
+      renderMessages();
+      poll(address);
+    }).catch(function (err) {
+      status(address + ": " + err.message);
+      forgetMailbox(address);
+      state.mailbox = null;
+      $("delete-mailbox").hidden = true;
+      $("mailbox-title").textContent = "No mailbox selected";
+      renderMailboxes();
+    });
+  }
+
+  function createMailbox() {
+    api("POST", "/mailboxes").then(function (content) {
+      rememberMailbox(content.mailbox.id);
+      openMailbox(content.mailbox.id);
+    }).catch(function (err) { status(err.message); });
+  }
+
+  function deleteMailbox() {
+    var address = state.mailbox;
+    if (!address || !confirm("Delete mailbox " + address + " and all of its messages?")) {
+      return;
+    }
+    api("DELETE", "/mailboxes/" + encodeURIComponent(address)).then(function () {
+      clearTimeout(state.timer);
+      forgetMailbox(address);
+      state.mailbox = null;
+      state.messages = [];
+      $("mailbox-title").textContent = "No mailbox selected";
+      $("delete-mailbox").hidden = true;
+      $("message").hidden = true;
+      renderMailboxes();
+      renderMessages();
+    }).catch(function (err) { status(err.message); });
+  }
+
+  // Live updates: ask for anything newer than the newest message seen so far.
+
+  function poll(address) {
+    state.timer = setTimeout(function () {
+      if (state.mailbox !== address) {
+        return;
+      }
+      var path = messagesPath(address) + "?order=desc&limit=" + PAGE_SIZE;
+      if (state.cursor) {
+        path += "&after=" + encodeURIComponent(state.cursor);
+      }
+      api("GET", path).then(function (content) {
+        if (state.mailbox !== address) {
+          return;
+        }
+        if (content.messages.length > 0) {
+          state.messages = content.messages.concat(state.messages);
+          renderMessages();
+        }
+        state.cursor = content.meta.prev_cursor || state.cursor;
+        status("Updated " + new Date().toLocaleTimeString());
+        poll(address);
+      }).catch(function (err) {
+        status(err.message);
+        poll(address);
+      });
+    }, POLL_INTERVAL);
+  }
+
+  // Messages
+
+  function renderMessages() {
+    var list = $("message-list");
+    list.textContent = "";
+    state.messages.forEach(function (msg) {
+      var li = document.createElement("li");
+      li.textContent = msg.subject || "(no subject)";
+      var from = document.createElement("small");
+      from.textContent = msg.sender + " · " + new Date(msg.received).toLocaleString();
+      li.appendChild(from);
+      li.classList.toggle("unseen", !msg.seen);
+      li.classList.toggle("selected", state.message !== null && msg.id === state.message.id);
+      li.addEventListener("click", function () { showMessage(msg.id); });
+      list.appendChild(li);
+    });
+  }
+
+  function messagePath(id) {
+    return messagesPath(state.mailbox) + "/" + encodeURIComponent(id);
+  }
+
+  function showMessage(id) {
+    api("GET", messagePath(id)).then(function (content) {
+      state.message = content.message;
+      renderMessage();
+      renderMessages();
+      if (!content.message.seen) {
+        return api("PATCH", messagePath(id), { message: { seen: true } }).then(function (updated) {
+          replaceMessage(updated.message);
+        });
+      }
+    }).catch(function (err) { status(err.message); });
+  }
+
+  function replaceMessage(msg) {
+    state.messages = state.messages.map(function (m) { return m.id === msg.id ? msg : m; });
+    renderMessages();
+  }
+
+  function deleteMessage() {
+    var msg = state.message;
+    if (!msg) {
+      return;
+    }
+    api("DELETE", messagePath(msg.id)).then(function () {
+      state.messages = state.messages.filter(function (m) { return m.id !== msg.id; });
+      state.message = null;
+      $("message").hidden = true;
+      renderMessages();
+    }).catch(function (err) { status(err.message); });
+  }
+
+  function looksLikeHTML(body) {
+    return /<\s*(html|body|div|p|table|br|span|a)\b/i.test(body);
+  }
+
+  function renderMessage() {
+    var msg = state.message;
+    $("message").hidden = false;
+    $("message-subject").textContent = msg.subject || "(no subject)";
+    $("message-meta").textContent = "From " + msg.sender + " on " + new Date(msg.received).toLocaleString();
+
+    // The iframe is sandboxed without allow-scripts or allow-same-origin, so message HTML can neither run scripts
+    // nor reach the API.
+    $("view-html").srcdoc = looksLikeHTML(msg.body) ? msg.body : "";
+    $("view-text").textContent = msg.body;
+    $("view-raw").textContent = JSON.stringify(msg, null, 2);
+
+    var headers = $("view-headers");
+    headers.textContent = "";
+    [
+      ["ID", msg.id],
+      ["Sequence", msg.seq],
+      ["From", msg.sender],
+      ["Subject", msg.subject],
+      ["Received", msg.received],
+      ["Flagged", msg.flagged ? "yes" : "no"],
+      ["Tags", (msg.tags || []).join(", ")]
+    ].forEach(function (pair) {
+      var row = headers.insertRow();
+      var th = document.createElement("th");
+      th.textContent = pair[0];
+      row.appendChild(th);
+      row.insertCell().textContent = pair[1];
+    });
+
+    showView(state.view === "html" && !looksLikeHTML(msg.body) ? "text" : state.view);
+  }
+
+  function selectView(view) {
+    state.view = view;
+    showView(view);
+  }
+
+  function showView(view) {
+    ["html", "text", "headers", "raw"].forEach(function (v) {
+      $("view-" + v).hidden = v !== view;
+    });
+    Array.prototype.forEach.call(document.querySelectorAll(".tabs button"), function (button) {
+      button.classList.toggle("active", button.dataset.view === view);
+    });
+  }
+
+  // Wiring
+
+  $("open-mailbox").addEventListener("submit", function (e) {
+    e.preventDefault();
+    var address = $("address").value.trim();
+    if (address) {
+      $("address").value = "";
+      openMailbox(address);
+    }
+  });
+  $("create-mailbox").addEventListener("click", createMailbox);
+  $("delete-mailbox").addEventListener("click", deleteMailbox);
+  $("delete-message").addEventListener("click", deleteMessage);
+  Array.prototype.forEach.call(document.querySelectorAll(".tabs button"), function (button) {
+    button.addEventListener("click", function () { selectView(button.dataset.view); });
+  });
+
+  renderMailboxes();
+  api("GET", "/mailboxes").then(function (content) {
+    content.mailboxes.forEach(function (box) { rememberMailbox(box.id); });
+    renderMailboxes();
+  }).catch(function (err) { status(err.message); }).then(function () {
+    if (state.mailboxes.length > 0) {
+      openMailbox(state.mailboxes[0]);
+    }
+  });
+})();
@@ -0,0 +1,49 @@
+// Package ui serves a single-page web interface for browsing mailboxes. The page is built from embedded static assets
+// and talks to ponyexpress through the public HTTP API only.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/brettbuddin/ponyexpress/server"
+)
+
+// Prefix is the path the interface is served under.
+const Prefix = "/ui/"
+
+//go:embed assets
+var assets embed.FS
+
+var files = http.FileServer(http.FS(mustSub(assets, "assets")))
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Index redirects to the interface.
+func Index(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	w.Header().Del("Content-Type")
+	http.Redirect(w, r.Request, Prefix, http.StatusMovedPermanently)
+}
+
+// Assets serves the files making up the interface.
+func Assets(ctx context.Context, w server.ResponseWriter, r *server.Request) {
+	// Let the file server work out the content type from the file name.
+	w.Header().Del("Content-Type")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src * data:")
+
+	req := *r.Request
+	url := *req.URL
+	url.Path = "/" + strings.TrimPrefix(r.URLParams.ByName("filepath"), "/")
+	req.URL = &url
+	files.ServeHTTP(w, &req)
+}
@@ -0,0 +1,52 @@
+package ui_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/go-playground/assert.v1"
+
+	"github.com/brettbuddin/ponyexpress/server"
+	"github.com/brettbuddin/ponyexpress/ui"
+)
+
+func newServer() *httptest.Server {
+	app := server.New(context.Background())
+	app.AddFilters(server.SetResponseHeader("Content-Type", "application/json"))
+	app.GET("/ui", ui.Index)
+	app.GET("/ui/*filepath", ui.Assets)
+	return httptest.NewServer(app)
+}
+
+func TestAssets(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	for path, contentType := range map[string]string{
+		"/ui/":          "text/html",
+		"/ui/app.js":    "text/javascript",
+		"/ui/style.css": "text/css",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		Equal(t, err, nil)
+		Equal(t, resp.StatusCode, http.StatusOK)
+		Equal(t, strings.HasPrefix(resp.Header.Get("Content-Type"), contentType), true)
+		NotEqual(t, resp.Header.Get("Content-Security-Policy"), "")
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(ts.URL + "/ui")
+	Equal(t, err, nil)
+	Equal(t, resp.Request.URL.Path, "/ui/")
+	buf, err := ioutil.ReadAll(resp.Body)
+	Equal(t, err, nil)
+	MatchRegex(t, string(buf), `<script src="app.js">`)
+
+	resp, err = http.Get(ts.URL + "/ui/missing.js")
+	Equal(t, err, nil)
+	Equal(t, resp.StatusCode, http.StatusNotFound)
+}